@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/gcode"
+)
+
+// `gcode` groups the commands working on sliced files.
+func runGcode(args []string) {
+	if len(args) == 0 {
+		log.Fatalln("Usage: timelapse-serial gcode inject|analyze [flags] <file>")
+	}
+	switch args[0] {
+	case "inject":
+		runGcodeInject(args[1:])
+	case "analyze":
+		runGcodeAnalyze(args[1:])
+	default:
+		log.Fatalln("Unknown gcode command", args[0])
+	}
+}
+
+// `gcode inject` is meant to be used as a slicer post-processing script: the
+// slicer gives it the path of the G-code it just wrote, which is rewritten in
+// place with the timelapse snippets.
+func runGcodeInject(args []string) {
+	flags := flag.NewFlagSet("gcode inject", flag.ExitOnError)
+	mode := flags.String("mode", string(gcode.ParkHover), "Where to move the head before each capture: hover, freeze, next-to-print or parked")
+	parkX := flags.Float64("parkX", 0, "X position of the head in parked mode")
+	parkY := flags.Float64("parkY", 0, "Y position of the head in parked mode")
+	yOffset := flags.Float64("yOffset", 35, "Distance between the back of the print and the head in hover and next-to-print modes")
+	feedrate := flags.Int("feedrate", 18000, "Feedrate of the moves to and from the capture position, in mm/min")
+	handshake := flags.Int("handshake", 0, "Pause the printer up to this many seconds after each capture, until the daemon resumes it (see CaptureHandshake)")
+	dwell := flags.Int("dwell", 300, "Time to wait after each capture when not using the handshake, in ms")
+	output := flags.String("o", "", "Write the result to this file instead of rewriting the input")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: timelapse-serial gcode inject [flags] <file.gcode>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	inputPath := flags.Arg(0)
+	outputPath := *output
+	if len(outputPath) == 0 {
+		outputPath = inputPath
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		log.Fatalln("Cannot open G-code", err)
+	}
+	defer input.Close()
+
+	// The slicer may be uploading the file as soon as we exit, it must never
+	// see half of it.
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), ".tmp-"+filepath.Base(outputPath))
+	if err != nil {
+		log.Fatalln("Cannot create output file", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	captures, err := gcode.Inject(input, tmp, gcode.InjectOptions{
+		Mode:             gcode.ParkMode(*mode),
+		ParkX:            *parkX,
+		ParkY:            *parkY,
+		YOffset:          *yOffset,
+		Feedrate:         *feedrate,
+		HandshakeSeconds: *handshake,
+		DwellMs:          *dwell,
+	})
+	tmp.Close()
+	if err != nil {
+		log.Fatalln("Cannot inject timelapse G-code:", err)
+	}
+	if err := os.Rename(tmp.Name(), outputPath); err != nil {
+		log.Fatalln("Cannot write output file", err)
+	}
+	fmt.Printf("Injected %d captures in %s\n", captures, outputPath)
+}
+
+// `gcode analyze` tells how many frames a print will produce, how much space
+// they take and how long the videos will be.
+func runGcodeAnalyze(args []string) {
+	flags := flag.NewFlagSet("gcode analyze", flag.ExitOnError)
+	configPath := flags.String("configPath", "", "The path of the config file, for the output directory and render profiles; defaults are used when omitted")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: timelapse-serial gcode analyze [flags] <file.gcode|file.bgcode>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var conf config.Config
+	if len(*configPath) > 0 {
+		conf = config.LoadConfig(*configPath)
+	}
+
+	input, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalln("Cannot open G-code", err)
+	}
+	defer input.Close()
+
+	analysis, err := gcode.Analyze(input)
+	if err != nil {
+		log.Fatalln("Cannot analyze G-code:", err)
+	}
+	printForecast(os.Stdout, gcode.MakeForecast(analysis, conf.Camera.OutputDir, &conf.FFMPEG))
+}
+
+func printForecast(w io.Writer, f gcode.Forecast) {
+	fmt.Fprintf(w, "Layers:          %d\n", f.Layers)
+	if f.CaptureMarkers > 0 {
+		fmt.Fprintf(w, "Capture markers: %d\n", f.CaptureMarkers)
+	} else {
+		fmt.Fprintln(w, "Capture markers: none, frames are counted per layer (see `gcode inject`)")
+	}
+	if f.EstimatedPrintTime > 0 {
+		fmt.Fprintf(w, "Print time:      %s\n", f.EstimatedPrintTime)
+	} else {
+		fmt.Fprintln(w, "Print time:      unknown")
+	}
+	fmt.Fprintf(w, "Frames:          %d\n", f.Frames)
+
+	sizeSource := "previous frames"
+	if !f.FrameSizeMeasured {
+		sizeSource = "no previous frames, guessed"
+	}
+	fmt.Fprintf(w, "Disk usage:      %s (%s per frame, %s)\n", gcode.FormatBytes(f.DiskUsage), gcode.FormatBytes(f.FrameSize), sizeSource)
+	if f.FreeSpace > 0 {
+		fmt.Fprintf(w, "Free space:      %s\n", gcode.FormatBytes(f.FreeSpace))
+		if !f.FitsOnDisk() {
+			fmt.Fprintln(w, "Warning: the frames will not fit in the output directory")
+		}
+	}
+	for _, video := range f.Videos {
+		fmt.Fprintf(w, "Video %q:  %s at %s fps\n", video.Profile, video.Duration.Round(100*time.Millisecond), video.FramesPerSecond)
+	}
+}
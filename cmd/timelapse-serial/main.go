@@ -3,45 +3,91 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 
-	"github.com/davidbyttow/govips/v2/vips"
 	"github.com/pyrho/timelapse-serial/internal/camera"
+	"github.com/pyrho/timelapse-serial/internal/capture"
 	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/ffmpeg"
+	"github.com/pyrho/timelapse-serial/internal/frames"
 	"github.com/pyrho/timelapse-serial/internal/interrupt_trap"
 	"github.com/pyrho/timelapse-serial/internal/serial"
+	"github.com/pyrho/timelapse-serial/internal/thumbnail"
 	"github.com/pyrho/timelapse-serial/internal/web"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "simulate":
+			runSimulate(os.Args[2:])
+			return
+		case "gcode":
+			runGcode(os.Args[2:])
+			return
+		case "migrate-frames":
+			runMigrateFrames(os.Args[2:])
+			return
+		case "render":
+			runRender(os.Args[2:])
+			return
+		}
+	}
+
 	configPath := flag.String("configPath", "/usr/local/etc/timelapse-serial.toml", "The path of the config file")
 	flag.Parse()
 	config := config.LoadConfig(*configPath)
 
-	c := camera.MakeCameraWrapper(config.Camera.OutputDir)
+	c := camera.MakeCameraWrapper(&config.Camera)
 
 	if len(config.Camera.CameraSerialNumber) > 0 {
-		go camera.MonitorCameraUsbEvents(&config.Camera.CameraSerialNumber, &c)
+		go camera.MonitorCameraUsbEvents(&config.Camera.CameraSerialNumber, c)
 	} else {
 		log.Println("Not monitoring camera plug events")
 	}
 
-	vips.Startup(&vips.Config{
-		ConcurrencyLevel: 1,
-		MaxCacheMem:      8 * 1024 * 1024,
-		MaxCacheSize:     8 * 1024 * 1024,
-		MaxCacheFiles:    8,
-	})
-	vips.LoggingSettings(nil, vips.LogLevelCritical)
 	interrupttrap.TrapInterrupt(func() {
 		c.Stop()
-		vips.Shutdown()
+		thumbnail.Shutdown()
 	})
 
-	onSerialMessageHandler := serial.CreateSerialMessageHandler(&c, &config.FFMPEG)
+	gcodeSender := serial.NewGcodeSender()
+
+	var printInfoCache *web.PrintInfoCache
+	if len(config.Web.PrinterUrl) > 0 {
+		printInfoCache = web.NewPrintInfoCache()
+		printInfoCache.StartLoop(config.Web.PrinterUrl, config.Web.PrusaLinkKey)
+	}
+
+	var analyzer *frames.Analyzer
+	if config.FrameAnalysis.Enabled {
+		analyzer = frames.NewAnalyzer(config.FrameAnalysis)
+	}
+
+	thumbnails := web.NewThumbnailCache(&config)
+	postProcessors := []capture.PostProcessor{
+		frames.CreateMetadataPostProcessor(printInfoCache.Temperatures, printInfoCache.Job, config.Camera.EmbedFrameMetadata, analyzer),
+	}
+	if config.Printer.StatusMessages {
+		postProcessors = append(postProcessors, serial.CreateStatusMessagePostProcessor(gcodeSender))
+	}
+	captureWorker := capture.NewWorker(c, config.Camera.WithDefaults().CaptureQueueSize, postProcessors...)
+	// Missing thumbnails are created when the folder is opened anyway
+	captureWorker.AddOptionalPostProcessors(web.CreateThumbnailPostProcessor(thumbnails))
+	captureWorker.Start()
+
+	render := func(snapshotsDir string) {
+		log.Println("Print done, creating timelapse...")
+		go ffmpeg.SpawnFFMPEG(snapshotsDir, config.FFMPEG.WithDefaults())
+	}
+	onSerialMessageHandler := serial.CreateSerialMessageHandler(c, captureWorker, gcodeSender, &config.Printer, render)
 	// This needs to be last
-	go serial.StartSerialLoop(&config, onSerialMessageHandler)
+	go serial.StartSerialLoop(&config, gcodeSender, onSerialMessageHandler)
 
-	go web.StartWebServer(&config)
+	go web.StartWebServer(&config, c, captureWorker, printInfoCache, thumbnails)
 
 	log.Println("Running...")
 
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pyrho/timelapse-serial/internal/camera"
+	"github.com/pyrho/timelapse-serial/internal/manifest"
+	"github.com/pyrho/timelapse-serial/internal/snaps"
+)
+
+// `migrate-frames` renames the frames named after their capture time
+// (`snap1700000000.jpg`) to sequence numbers (`frame-000001.jpg`), either in
+// a single print folder or in every folder of the output directory.
+func runMigrateFrames(args []string) {
+	flags := flag.NewFlagSet("migrate-frames", flag.ExitOnError)
+	dryRun := flags.Bool("dryRun", false, "Only print what would be renamed")
+	thumbnailCacheDir := flags.String("thumbnailCacheDir", "", "ThumbnailCacheDir of the config, defaults to .thumbnails in OutputDir")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: timelapse-serial migrate-frames [flags] <OutputDir or print folder>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	dirs := []string{flags.Arg(0)}
+	if fileNames, err := snaps.List(flags.Arg(0)); err != nil {
+		log.Fatalln("Cannot read", flags.Arg(0), err)
+	} else if len(fileNames) == 0 {
+		entries, _ := os.ReadDir(flags.Arg(0))
+		dirs = nil
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, filepath.Join(flags.Arg(0), entry.Name()))
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		renames, err := snaps.Migrate(dir, *dryRun, camera.CapturesLogFileName, manifest.FileName)
+		if err != nil {
+			log.Fatalln("Cannot migrate", dir, err)
+		}
+		if len(renames) == 0 {
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("%s: %d frames would be renamed\n", dir, len(renames))
+			continue
+		}
+		fmt.Printf("%s: %d frames renamed\n", dir, len(renames))
+
+		// The cached thumbnails are indexed by frame name, they are created
+		// again when the folder is opened
+		cacheDir := *thumbnailCacheDir
+		if len(cacheDir) == 0 {
+			cacheDir = filepath.Join(filepath.Dir(dir), ".thumbnails")
+		}
+		if err := os.RemoveAll(filepath.Join(cacheDir, filepath.Base(dir))); err != nil {
+			log.Println("Cannot remove the cached thumbnails of", dir, err)
+		}
+	}
+}
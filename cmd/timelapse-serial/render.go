@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/ffmpeg"
+)
+
+// `render` renders the videos of a print folder again, e.g. after changing
+// which frames are excluded.
+func runRender(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	configPath := flags.String("configPath", "", "The path of the config file, for the render profiles; defaults are used when omitted")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: timelapse-serial render [flags] <print folder>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var conf config.Config
+	if len(*configPath) > 0 {
+		conf = config.LoadConfig(*configPath)
+	}
+	ffmpeg.SpawnFFMPEG(flags.Arg(0), conf.FFMPEG.WithDefaults())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pyrho/timelapse-serial/internal/camera"
+	"github.com/pyrho/timelapse-serial/internal/capture"
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/ffmpeg"
+	"github.com/pyrho/timelapse-serial/internal/serial"
+)
+
+// `replay` feeds a serial recording (see `RecordPath`), or a plain text file
+// of printer output, through the serial message handler with a fake camera.
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := flags.String("configPath", "", "The path of the config file, defaults are used when omitted")
+	outputDir := flags.String("outputDir", "", "Where the fake camera saves its pictures, defaults to a temporary directory")
+	speed := flags.Float64("speed", 1, "Replay speed factor, 0 replays everything without waiting")
+	renderVideos := flags.Bool("render", false, "Render the timelapse of each replayed print, and wait for it")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: timelapse-serial replay [flags] <recording>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var conf config.Config
+	if len(*configPath) > 0 {
+		conf = config.LoadConfig(*configPath)
+	}
+
+	if len(*outputDir) == 0 {
+		dir, err := os.MkdirTemp("", "timelapse-serial-replay")
+		if err != nil {
+			log.Fatalln("Cannot create output directory", err)
+		}
+		*outputDir = dir
+	}
+	log.Println("Replaying", flags.Arg(0), "into", *outputDir)
+
+	cam := camera.MakeFakeCamera(*outputDir)
+	captureWorker := capture.NewWorker(cam, conf.Camera.WithDefaults().CaptureQueueSize)
+	captureWorker.Start()
+
+	// Never attached to a port, G-code sent by the handler is logged and dropped
+	gcodeSender := serial.NewGcodeSender()
+	// Renders are waited for, the process would otherwise exit in the middle
+	var renders sync.WaitGroup
+	render := func(snapshotsDir string) {
+		if !*renderVideos {
+			log.Println("Print done, not rendering it (see -render)")
+			return
+		}
+		log.Println("Print done, creating timelapse...")
+		renders.Add(1)
+		go func() {
+			defer renders.Done()
+			ffmpeg.SpawnFFMPEG(snapshotsDir, conf.FFMPEG.WithDefaults())
+		}()
+	}
+	onSerialMessageHandler := serial.CreateSerialMessageHandler(cam, captureWorker, gcodeSender, &conf.Printer, render)
+
+	if err := serial.Replay(context.Background(), flags.Arg(0), *speed, onSerialMessageHandler); err != nil {
+		log.Fatalln("Replay failed:", err)
+	}
+
+	// Wait for the pending captures, then for the renders they started
+	captureWorker.Wait()
+	renders.Wait()
+
+	printReplaySummary(*outputDir, captureWorker.Overruns())
+}
+
+func printReplaySummary(outputDir string, overruns uint64) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		log.Fatalln("Cannot read output directory", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		stats := camera.GetCaptureStats(filepath.Join(outputDir, entry.Name()))
+		fmt.Printf("%s: %d captures, %d failed\n", entry.Name(), stats.Total, stats.Failed)
+	}
+	fmt.Printf("%d captures dropped (queue full)\n", overruns)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/simulator"
+)
+
+// `simulate` pretends to be a printer running a timelapse-enabled print, on
+// a pseudo-terminal the daemon can use as its serial port.
+func runSimulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	layers := flags.Int("layers", 20, "Number of layers, i.e. captures")
+	layerTime := flags.Duration("layerTime", 5*time.Second, "Time spent printing each layer")
+	temperatureInterval := flags.Duration("temperatureInterval", 2*time.Second, "How often temperatures are reported")
+	respectGcode := flags.Bool("respectGcode", false, "Answer the G-code sent by the daemon with ok")
+	handshake := flags.Duration("handshake", 0, "Wait up to this long for M108 after each capture (see CaptureHandshake)")
+	startDelay := flags.Duration("startDelay", 5*time.Second, "Time left to start the daemon before the print starts")
+	flags.Parse(args)
+
+	pty, err := simulator.OpenPty()
+	if err != nil {
+		log.Fatalln("Cannot create pty", err)
+	}
+	defer pty.Close()
+
+	// Printed on stdout so that scripts can pick it up
+	fmt.Println(pty.SlavePath)
+	log.Printf("Simulated printer on %s, set it as the PortName of the daemon\n", pty.SlavePath)
+
+	ctx := context.Background()
+	printer := simulator.NewPrinter(pty.Master, simulator.Options{
+		Layers:              *layers,
+		LayerTime:           *layerTime,
+		TemperatureInterval: *temperatureInterval,
+		RespectGcode:        *respectGcode,
+		Handshake:           *handshake,
+	})
+	go printer.ReadGcode(ctx, pty.Master)
+
+	log.Println("Print starting in", *startDelay)
+	time.Sleep(*startDelay)
+	if err := printer.Print(ctx); err != nil {
+		log.Fatalln("Print failed:", err)
+	}
+	log.Println("Print done")
+}
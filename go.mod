@@ -5,18 +5,17 @@ go 1.22.0
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/davidbyttow/govips/v2 v2.14.0
-	github.com/h2non/bimg v1.1.9
+	github.com/jochenvg/go-udev v0.0.0-20171110120927-d6b62d56d37b
 	github.com/jonmol/gphoto2 v1.0.1
 	github.com/rubiojr/go-usbmon v0.0.0-20240513072523-d5cbf336b315
 	go.bug.st/serial v1.6.2
+	golang.org/x/image v0.16.0
+	golang.org/x/sys v0.20.0
 )
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
 	github.com/jkeiser/iter v0.0.0-20200628201005-c8aa0ae784d1 // indirect
-	github.com/jochenvg/go-udev v0.0.0-20171110120927-d6b62d56d37b // indirect
-	golang.org/x/image v0.16.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
 )
@@ -1,37 +1,93 @@
 package camera
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/jonmol/gphoto2"
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/snaps"
 	"github.com/pyrho/timelapse-serial/internal/utils"
 )
 
+var ErrNoCamera = errors.New("there is no camera instance")
+
+var errNoSettings = errors.New("the camera has no settings")
+
+// The part of the gphoto2 camera the captures use, so that tests can fake it.
+type handle interface {
+	CaptureDownload(buffer io.Writer, leaveOnCamera bool) error
+	Exit() error
+	Free() error
+}
+
+// CameraWrapper is safe for concurrent use: it is driven by the capture
+// worker, the USB monitor, the web server and the interrupt trap.
+//
+// `mu` serializes every gphoto2 call and guards `instance`, it can be held
+// for a whole capture. `stateMu` guards what can be read without waiting for
+// the camera (its status and the snapshots directory).
+// When both are needed, `mu` is always taken first.
 type CameraWrapper struct {
-	instance                   *gphoto2.Camera
+	mu       sync.Mutex
+	instance handle
+	// Opens the camera matching the selector, initCam outside of tests
+	open func(selector DeviceSelector) (handle, Device, error)
+
+	stateMu                    sync.RWMutex
+	status                     CameraStatus
 	currentSnapshotDirFullPath string
-	baseOutputDir              string
+
+	selector      DeviceSelector
+	settings      map[string]string
+	snapTimeout   time.Duration
+	snapAttempts  int
+	baseOutputDir string
 }
 type CameraWrapperInterface interface {
 	Start()
 	Stop()
-	Snap()
+	Snap() (string, error)
 	CreateNewSnapshotsDir()
 	GetCurrentSnapshotsDir() string
+	GetDevice() Device
+	GetStatus() CameraStatus
+	Disconnect()
+	GetSettings() ([]Setting, error)
+	SetSetting(name string, value string) error
 }
 
-func MakeCameraWrapper(baseOutputDir string) CameraWrapper {
-	if err := utils.CreateDirectoryIfNotExists(baseOutputDir); err != nil {
-		log.Fatal("Output directory does not exists, and we cannot create it:", baseOutputDir)
+func MakeCameraWrapper(cameraConfig *config.Camera) *CameraWrapper {
+	conf := cameraConfig.WithDefaults()
+	if err := utils.CreateDirectoryIfNotExists(conf.OutputDir); err != nil {
+		log.Fatal("Output directory does not exists, and we cannot create it:", conf.OutputDir)
 	}
 
-	return CameraWrapper{baseOutputDir: baseOutputDir}
+	return &CameraWrapper{
+		baseOutputDir: conf.OutputDir,
+		selector: DeviceSelector{
+			SerialNumber: conf.CameraSerialNumber,
+			Port:         conf.CameraPort,
+			Model:        conf.CameraModel,
+		},
+		settings:     conf.Settings,
+		snapTimeout:  time.Duration(conf.SnapTimeoutInSeconds) * time.Second,
+		snapAttempts: conf.SnapAttempts,
+		status:       CameraStatus{State: StateDisconnected, Since: time.Now()},
+		open:         openCam,
+	}
 }
 
 func (c *CameraWrapper) GetCurrentSnapshotsDir() string {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
 	if len(c.currentSnapshotDirFullPath) == 0 {
 		return c.baseOutputDir + "/orphans"
 	} else {
@@ -40,20 +96,88 @@ func (c *CameraWrapper) GetCurrentSnapshotsDir() string {
 }
 
 func (c *CameraWrapper) CreateNewSnapshotsDir() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
 	c.currentSnapshotDirFullPath =
 		utils.CreateNewPhotoDirectory(c.baseOutputDir)
 	log.Println("Created new Snapshot directory: " + c.currentSnapshotDirFullPath)
 }
 
 func (c *CameraWrapper) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start()
+}
+
+// Must be called with `mu` held.
+func (c *CameraWrapper) start() {
 	if c.instance != nil {
-		c.Stop()
+		c.stop()
+	}
+	instance, device, err := c.open(c.selector)
+	if err != nil {
+		log.Println("Cannot start CameraWrapper:", err)
+		if errors.Is(err, ErrCameraNotFound) {
+			c.setStatus(StateDisconnected, Device{}, err)
+		} else {
+			c.setStatus(StateError, Device{}, err)
+		}
+		return
+	}
+	c.instance = instance
+	c.setStatus(StateConnected, device, nil)
+	if cam, isGphoto := instance.(*gphoto2.Camera); isGphoto {
+		applySettings(cam, c.settings)
+	}
+	log.Println("Started CameraWrapper with", device)
+}
+
+func (c *CameraWrapper) GetSettings() ([]Setting, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.instance == nil {
+		return nil, ErrNoCamera
+	}
+	cam, isGphoto := c.instance.(*gphoto2.Camera)
+	if !isGphoto {
+		return nil, errNoSettings
 	}
-	c.instance = initCam()
-	log.Println("Started CameraWrapper")
+	return listSettings(cam)
+}
+
+func (c *CameraWrapper) SetSetting(name string, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.instance == nil {
+		return ErrNoCamera
+	}
+	cam, isGphoto := c.instance.(*gphoto2.Camera)
+	if !isGphoto {
+		return errNoSettings
+	}
+	return setSetting(cam, name, value)
 }
 
 func (c *CameraWrapper) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop()
+}
+
+// To be called when the camera was unplugged, the handle is useless by now.
+// It is not an error, the camera will be started again when it comes back.
+func (c *CameraWrapper) Disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	log.Println("Camera unplugged")
+	c.stop()
+	// Also when there was no handle, e.g. the camera was unplugged while
+	// being re-initialized after a failed capture
+	c.setStatus(StateDisconnected, Device{}, nil)
+}
+
+// Must be called with `mu` held.
+func (c *CameraWrapper) stop() {
 	if c.instance != nil {
 		if err := c.instance.Exit(); err != nil {
 			log.Println("Cannot exit camera instance")
@@ -62,6 +186,7 @@ func (c *CameraWrapper) Stop() {
 			log.Println("Cannot free camera instance")
 		}
 		c.instance = nil
+		c.setStatus(StateDisconnected, Device{}, nil)
 		log.Println("Stopped cameraWrapper")
 	} else {
 		log.Println("Camera was already stopped")
@@ -69,33 +194,144 @@ func (c *CameraWrapper) Stop() {
 	}
 }
 
-func (c *CameraWrapper) Snap() {
-	if c.instance == nil {
-		log.Println("There is no camera instance, not taking a pic")
-		return
-	}
-
-	var currentSnapshotDir string
+// Takes a picture and saves it in the current snapshots directory.
+// The capture is abandoned after `SnapTimeoutInSeconds`, and recoverable
+// gphoto2 errors (I/O, busy, timeout...) trigger a re-init of the camera
+// before trying again, up to `SnapAttempts` times.
+// Every call records its outcome in the snapshots directory.
+// Returns the path of the picture.
+func (c *CameraWrapper) Snap() (string, error) {
 	// This means that the program was spawned when a print
 	// was already in progress.
 	// We still want to save the pics, so just store them in the
 	// orphans folder
-	if len(c.currentSnapshotDirFullPath) == 0 {
-		currentSnapshotDir = c.baseOutputDir + "/orphans"
-	} else {
-		currentSnapshotDir = c.currentSnapshotDirFullPath
+	currentSnapshotDir := c.GetCurrentSnapshotsDir()
+	if err := utils.CreateDirectoryIfNotExists(currentSnapshotDir); err != nil {
+		log.Println("Cannot create snapshot directory", currentSnapshotDir, err)
+		return "", err
 	}
 
-	snapFilename := fmt.Sprintf("%s/snap%d.jpg", currentSnapshotDir, time.Now().Unix())
+	startedAt := time.Now()
+	outcome := CaptureOutcome{Time: startedAt}
+	defer func() {
+		outcome.LatencyMs = time.Since(startedAt).Milliseconds()
+		if err := recordCaptureOutcome(currentSnapshotDir, outcome); err != nil {
+			log.Println("Cannot record capture outcome", err)
+		}
+	}()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.instance == nil {
+		log.Println("There is no camera instance, not taking a pic")
+		outcome.Error = ErrNoCamera.Error()
+		return "", ErrNoCamera
+	}
+
+	c.setState(StateBusy, nil)
+	var err error
+	for outcome.Attempts = 1; outcome.Attempts <= c.snapAttempts; outcome.Attempts++ {
+		var image []byte
+		image, err = captureWithTimeout(c.instance, c.snapTimeout)
+		if err == nil {
+			var snapFilename string
+			if snapFilename, err = snapPath(currentSnapshotDir); err != nil {
+				break
+			}
+			if err = utils.WriteFileAtomically(snapFilename, image); err != nil {
+				break
+			}
+			outcome.FileName = filepath.Base(snapFilename)
+			c.setState(StateConnected, nil)
+			return snapFilename, nil
+		}
+
+		log.Printf("Capture attempt %d/%d failed: %v\n", outcome.Attempts, c.snapAttempts, err)
+		if errors.Is(err, errCaptureTimeout) {
+			// The gphoto2 call is still running in its goroutine, freeing
+			// the handle under its feet is not an option, so we leak it and
+			// hope a new one can be opened.
+			c.instance = nil
+			c.setStatus(StateError, Device{}, err)
+			c.start()
+		} else if isRecoverable(err) {
+			c.start()
+		} else {
+			break
+		}
+		if c.instance == nil {
+			err = fmt.Errorf("cannot re-init camera after failed capture: %w", err)
+			break
+		}
+	}
+
+	log.Println("Failed to capture!", err)
+	if c.instance != nil {
+		c.setState(StateError, err)
+	}
+	outcome.Error = err.Error()
+	return "", err
+}
 
-	f, err := os.Create(snapFilename)
+// Where the next snap of the directory is saved, numbered after the ones
+// already there.
+func snapPath(snapshotsDir string) (string, error) {
+	sequence, err := snaps.NextSequence(snapshotsDir)
 	if err != nil {
-		log.Println("Failed to create temp file", snapFilename, "giving up!", err)
-		return
+		return "", err
+	}
+	return filepath.Join(snapshotsDir, snaps.FileName(sequence)), nil
+}
+
+var errCaptureTimeout = errors.New("timed out while capturing")
+
+// Runs the capture in its own goroutine so that a hung camera cannot block
+// the caller forever.
+func captureWithTimeout(cam handle, timeout time.Duration) ([]byte, error) {
+	type result struct {
+		image []byte
+		err   error
+	}
+	// Buffered so that the goroutine can always exit, even when nobody is
+	// listening anymore.
+	done := make(chan result, 1)
+	go func() {
+		var buf bytes.Buffer
+		err := cam.CaptureDownload(&buf, false)
+		done <- result{buf.Bytes(), err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.image, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("%w after %s", errCaptureTimeout, timeout)
 	}
+}
 
-	if err := c.instance.CaptureDownload(f, false); err != nil {
-		log.Println("Failed to capture!", err)
+// Errors which are likely to go away after re-initializing the camera.
+func isRecoverable(err error) bool {
+	var gpErr *gphoto2.GphotoError
+	if !errors.As(err, &gpErr) {
+		return false
+	}
+	switch gpErr.Code {
+	case gphoto2.Error,
+		gphoto2.ErrorIO,
+		gphoto2.ErrorTimeout,
+		gphoto2.ErrorIORead,
+		gphoto2.ErrorIOWrite,
+		gphoto2.ErrorIOUSBClearHalt,
+		gphoto2.ErrorIOUSBFind,
+		gphoto2.ErrorIOUSBClaim,
+		gphoto2.ErrorIOLock,
+		gphoto2.ErrorCorruptedData,
+		gphoto2.ErrorCameraBusy,
+		gphoto2.ErrorosFailure:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -120,16 +356,27 @@ func warmupCamera(camInstance *gphoto2.Camera) {
 
 }
 
-// Initializes the gphoto2 instance to the first avaialable camera
-// Will return nil if no cameras are found.
-func initCam() *gphoto2.Camera {
-	// Calling `NewCamera` with `""` will connect to the first available camera
-	c, err := gphoto2.NewCamera("")
+// initCam as a handle, without the typed nil on errors.
+func openCam(selector DeviceSelector) (handle, Device, error) {
+	cam, device, err := initCam(selector)
 	if err != nil {
-		log.Println("No cameras detected")
-		return nil
-		// panic(fmt.Sprintf("%s: %s", "Failed to connect to camera, make sure it's around!", err))
+		return nil, Device{}, err
+	}
+	return cam, device, nil
+}
+
+// Initializes the gphoto2 instance to the camera matching the selector.
+// Will return an error wrapping ErrCameraNotFound if that camera is not
+// plugged in.
+func initCam(selector DeviceSelector) (*gphoto2.Camera, Device, error) {
+	device, err := findDevice(selector)
+	if err != nil {
+		return nil, Device{}, err
+	}
+	c, err := openDevice(device)
+	if err != nil {
+		return nil, Device{}, fmt.Errorf("failed to connect to %s: %w", device, err)
 	}
 	warmupCamera(c)
-	return c
+	return c, device, nil
 }
@@ -0,0 +1,271 @@
+package camera
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmol/gphoto2"
+)
+
+// Stands in for the gphoto2 handle, each capture gets the next result of
+// `captures`, then succeeds.
+type fakeHandle struct {
+	mu       sync.Mutex
+	captures []func(w io.Writer) error
+	exited   bool
+}
+
+func (h *fakeHandle) CaptureDownload(w io.Writer, leaveOnCamera bool) error {
+	h.mu.Lock()
+	var capture func(w io.Writer) error
+	if len(h.captures) > 0 {
+		capture, h.captures = h.captures[0], h.captures[1:]
+	}
+	h.mu.Unlock()
+	if capture != nil {
+		return capture(w)
+	}
+	_, err := w.Write([]byte("picture"))
+	return err
+}
+
+func (h *fakeHandle) Exit() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.exited = true
+	return nil
+}
+
+func (h *fakeHandle) Free() error {
+	return nil
+}
+
+func (h *fakeHandle) isExited() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.exited
+}
+
+var fakeDeviceForTests = Device{Model: "Test camera", Port: "usb:001,002"}
+
+// A camera whose handles are opened from `handles`, in order. Once they
+// are all used, the camera is not found.
+func makeTestCameraWrapper(t *testing.T, handles ...*fakeHandle) (*CameraWrapper, *sync.Mutex) {
+	var mu sync.Mutex
+	c := &CameraWrapper{
+		baseOutputDir: t.TempDir(),
+		snapTimeout:   100 * time.Millisecond,
+		snapAttempts:  3,
+		status:        CameraStatus{State: StateDisconnected, Since: time.Now()},
+	}
+	c.open = func(selector DeviceSelector) (handle, Device, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(handles) == 0 {
+			return nil, Device{}, ErrCameraNotFound
+		}
+		h := handles[0]
+		handles = handles[1:]
+		return h, fakeDeviceForTests, nil
+	}
+	return c, &mu
+}
+
+func TestSnapReinitializesAfterTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	hung := &fakeHandle{captures: []func(io.Writer) error{
+		func(io.Writer) error {
+			<-release
+			return nil
+		},
+	}}
+	fresh := &fakeHandle{}
+	c, _ := makeTestCameraWrapper(t, hung, fresh)
+	c.Start()
+
+	path, err := c.Snap()
+	if err != nil {
+		t.Fatal("Snap failed:", err)
+	}
+	if len(path) == 0 {
+		t.Fatal("Snap returned no path")
+	}
+	if status := c.GetStatus(); status.State != StateConnected {
+		t.Errorf("status is %s, want %s", status.State, StateConnected)
+	}
+	// The hung handle is still in use by its goroutine
+	if hung.isExited() {
+		t.Error("the hung handle was exited")
+	}
+
+	outcomes, err := ReadCaptureOutcomes(c.GetCurrentSnapshotsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 1 || outcomes[0].Attempts != 2 || outcomes[0].Failed() {
+		t.Errorf("outcomes are %+v, want one successful capture in 2 attempts", outcomes)
+	}
+}
+
+func TestSnapReinitializesAfterRecoverableError(t *testing.T) {
+	failing := &fakeHandle{captures: []func(io.Writer) error{
+		func(io.Writer) error { return &gphoto2.GphotoError{Code: gphoto2.ErrorIO} },
+	}}
+	c, _ := makeTestCameraWrapper(t, failing, &fakeHandle{})
+	c.Start()
+
+	if _, err := c.Snap(); err != nil {
+		t.Fatal("Snap failed:", err)
+	}
+	if !failing.isExited() {
+		t.Error("the failing handle was not exited")
+	}
+}
+
+func TestSnapGivesUpWhenTheCameraIsGone(t *testing.T) {
+	failing := &fakeHandle{captures: []func(io.Writer) error{
+		func(io.Writer) error { return &gphoto2.GphotoError{Code: gphoto2.ErrorIO} },
+	}}
+	c, _ := makeTestCameraWrapper(t, failing)
+	c.Start()
+
+	if _, err := c.Snap(); err == nil {
+		t.Fatal("Snap succeeded without a camera")
+	}
+	if status := c.GetStatus(); status.State != StateDisconnected {
+		t.Errorf("status is %s, want %s", status.State, StateDisconnected)
+	}
+	if _, err := c.Snap(); !errors.Is(err, ErrNoCamera) {
+		t.Errorf("second Snap returned %v, want %v", err, ErrNoCamera)
+	}
+}
+
+func TestSnapDoesNotRetryOtherErrors(t *testing.T) {
+	failure := errors.New("not a gphoto2 error")
+	h := &fakeHandle{captures: []func(io.Writer) error{
+		func(io.Writer) error { return failure },
+	}}
+	c, _ := makeTestCameraWrapper(t, h)
+	c.Start()
+
+	if _, err := c.Snap(); !errors.Is(err, failure) {
+		t.Fatalf("Snap returned %v, want %v", err, failure)
+	}
+	if status := c.GetStatus(); status.State != StateError {
+		t.Errorf("status is %s, want %s", status.State, StateError)
+	}
+	outcomes, _ := ReadCaptureOutcomes(c.GetCurrentSnapshotsDir())
+	if len(outcomes) != 1 || outcomes[0].Attempts != 1 {
+		t.Errorf("outcomes are %+v, want one failed attempt", outcomes)
+	}
+}
+
+func TestDisconnectAndReconnect(t *testing.T) {
+	first, second := &fakeHandle{}, &fakeHandle{}
+	c, _ := makeTestCameraWrapper(t, first, second)
+	c.Start()
+	if status := c.GetStatus(); status.State != StateConnected || status.Device != fakeDeviceForTests {
+		t.Fatalf("status is %+v after start", status)
+	}
+
+	c.Disconnect()
+	if !first.isExited() {
+		t.Error("the handle was not exited")
+	}
+	if status := c.GetStatus(); status.State != StateDisconnected || status.Device != (Device{}) {
+		t.Errorf("status is %+v after disconnect", status)
+	}
+	if _, err := c.Snap(); !errors.Is(err, ErrNoCamera) {
+		t.Errorf("Snap returned %v while disconnected, want %v", err, ErrNoCamera)
+	}
+
+	c.Start()
+	if _, err := c.Snap(); err != nil {
+		t.Error("Snap failed after reconnecting:", err)
+	}
+}
+
+func TestDisconnectWithoutHandle(t *testing.T) {
+	// The camera cannot be opened, the status is an error
+	c, mu := makeTestCameraWrapper(t)
+	c.open = func(selector DeviceSelector) (handle, Device, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return nil, Device{}, errors.New("cannot open")
+	}
+	c.Start()
+	if status := c.GetStatus(); status.State != StateError {
+		t.Fatalf("status is %s, want %s", status.State, StateError)
+	}
+
+	c.Disconnect()
+	if status := c.GetStatus(); status.State != StateDisconnected || len(status.Error) > 0 {
+		t.Errorf("status is %+v after disconnect, want %s", status, StateDisconnected)
+	}
+}
+
+// Meant for `go test -race`: the USB monitor, the capture worker and the web
+// server all use the camera at once.
+func TestConcurrentUse(t *testing.T) {
+	handles := make([]*fakeHandle, 50)
+	for i := range handles {
+		handles[i] = &fakeHandle{}
+	}
+	c, _ := makeTestCameraWrapper(t, handles...)
+	c.Start()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			c.Snap()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			c.Disconnect()
+			c.Start()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			c.GetStatus()
+			c.GetDevice()
+			c.GetCurrentSnapshotsDir()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestFakeCamera(t *testing.T) {
+	c := MakeFakeCamera(t.TempDir())
+	if _, err := c.Snap(); !errors.Is(err, ErrNoCamera) {
+		t.Errorf("Snap returned %v before start, want %v", err, ErrNoCamera)
+	}
+
+	c.Start()
+	c.CreateNewSnapshotsDir()
+	if _, err := c.Snap(); err != nil {
+		t.Fatal("Snap failed:", err)
+	}
+	c.Disconnect()
+	if status := c.GetStatus(); status.State != StateDisconnected {
+		t.Errorf("status is %s after disconnect, want %s", status.State, StateDisconnected)
+	}
+	c.Start()
+	if _, err := c.Snap(); err != nil {
+		t.Fatal("Snap failed after reconnecting:", err)
+	}
+
+	stats := GetCaptureStats(c.GetCurrentSnapshotsDir())
+	if stats.Total != 2 || stats.Failed != 0 {
+		t.Errorf("stats are %+v, want 2 successful captures", stats)
+	}
+}
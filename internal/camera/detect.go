@@ -0,0 +1,256 @@
+package camera
+
+// #cgo LDFLAGS: -lgphoto2 -lgphoto2_port
+// #include <stdlib.h>
+// #include <gphoto2/gphoto2.h>
+//
+// static int open_camera_on_port(Camera **out, GPContext *ctx, const char *model, const char *port) {
+// 	Camera *cam = NULL;
+// 	CameraAbilitiesList *abilitiesList = NULL;
+// 	GPPortInfoList *portInfoList = NULL;
+// 	CameraAbilities abilities;
+// 	GPPortInfo portInfo;
+// 	int ret, idx;
+//
+// 	if ((ret = gp_camera_new(&cam)) < GP_OK) return ret;
+//
+// 	if ((ret = gp_abilities_list_new(&abilitiesList)) < GP_OK) goto fail;
+// 	if ((ret = gp_abilities_list_load(abilitiesList, ctx)) < GP_OK) goto fail;
+// 	if ((idx = gp_abilities_list_lookup_model(abilitiesList, model)) < GP_OK) { ret = idx; goto fail; }
+// 	if ((ret = gp_abilities_list_get_abilities(abilitiesList, idx, &abilities)) < GP_OK) goto fail;
+// 	if ((ret = gp_camera_set_abilities(cam, abilities)) < GP_OK) goto fail;
+//
+// 	if ((ret = gp_port_info_list_new(&portInfoList)) < GP_OK) goto fail;
+// 	if ((ret = gp_port_info_list_load(portInfoList)) < GP_OK) goto fail;
+// 	if ((idx = gp_port_info_list_lookup_path(portInfoList, port)) < GP_OK) { ret = idx; goto fail; }
+// 	if ((ret = gp_port_info_list_get_info(portInfoList, idx, &portInfo)) < GP_OK) goto fail;
+// 	if ((ret = gp_camera_set_port_info(cam, portInfo)) < GP_OK) goto fail;
+//
+// 	if ((ret = gp_camera_init(cam, ctx)) < GP_OK) goto fail;
+//
+// 	gp_abilities_list_free(abilitiesList);
+// 	gp_port_info_list_free(portInfoList);
+// 	*out = cam;
+// 	return GP_OK;
+//
+// fail:
+// 	if (abilitiesList) gp_abilities_list_free(abilitiesList);
+// 	if (portInfoList) gp_port_info_list_free(portInfoList);
+// 	gp_camera_unref(cam);
+// 	return ret;
+// }
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/jonmol/gphoto2"
+)
+
+// Device describes a camera as reported by gphoto2's autodetection.
+type Device struct {
+	Model        string
+	Port         string
+	SerialNumber string
+}
+
+func (d Device) String() string {
+	if len(d.Model) == 0 {
+		return "none"
+	}
+	if len(d.SerialNumber) > 0 {
+		return fmt.Sprintf("%s on %s (serial %s)", d.Model, d.Port, d.SerialNumber)
+	}
+	return fmt.Sprintf("%s on %s", d.Model, d.Port)
+}
+
+// DeviceSelector tells which camera to use when several are plugged in.
+// Fields are checked in the following order: Port, SerialNumber, Model.
+// When all of them are empty, the first detected camera is used.
+type DeviceSelector struct {
+	SerialNumber string
+	Port         string
+	Model        string
+}
+
+func (s DeviceSelector) String() string {
+	switch {
+	case len(s.Port) > 0:
+		return "port " + s.Port
+	case len(s.SerialNumber) > 0:
+		return "serial " + s.SerialNumber
+	case len(s.Model) > 0:
+		return "model " + s.Model
+	default:
+		return "first available camera"
+	}
+}
+
+func (s DeviceSelector) matches(d Device) bool {
+	switch {
+	case len(s.Port) > 0:
+		return d.Port == s.Port
+	case len(s.SerialNumber) > 0:
+		return d.SerialNumber == s.SerialNumber
+	case len(s.Model) > 0:
+		return d.Model == s.Model
+	default:
+		return true
+	}
+}
+
+var ErrCameraNotFound = errors.New("camera not found")
+
+func gphotoError(res C.int) error {
+	return fmt.Errorf("%s (%d)", C.GoString(C.gp_result_as_string(res)), int(res))
+}
+
+// listDevices returns every camera gphoto2 can see, with the USB serial
+// number resolved from sysfs when possible.
+func listDevices() ([]Device, error) {
+	ctx := C.gp_context_new()
+	if ctx == nil {
+		return nil, errors.New("cannot create gphoto2 context")
+	}
+	defer C.gp_context_unref(ctx)
+
+	var list *C.CameraList
+	if res := C.gp_list_new(&list); res < C.GP_OK {
+		return nil, gphotoError(res)
+	}
+	defer C.gp_list_free(list)
+
+	count := C.gp_camera_autodetect(list, ctx)
+	if count < C.GP_OK {
+		return nil, gphotoError(count)
+	}
+
+	devices := make([]Device, 0, int(count))
+	for i := C.int(0); i < count; i++ {
+		var name, value *C.char
+		C.gp_list_get_name(list, i, &name)
+		C.gp_list_get_value(list, i, &value)
+		port := C.GoString(value)
+		devices = append(devices, Device{
+			Model:        C.GoString(name),
+			Port:         port,
+			SerialNumber: usbSerialFromPort(port),
+		})
+	}
+	return devices, nil
+}
+
+// findDevice returns the first detected camera matching the selector.
+func findDevice(selector DeviceSelector) (Device, error) {
+	devices, err := listDevices()
+	if err != nil {
+		return Device{}, err
+	}
+	for _, d := range devices {
+		if selector.matches(d) {
+			return d, nil
+		}
+	}
+
+	detected := make([]string, len(devices))
+	for i, d := range devices {
+		detected[i] = d.String()
+	}
+	return Device{}, fmt.Errorf("%w: looking for %s, detected [%s]",
+		ErrCameraNotFound, selector, strings.Join(detected, ", "))
+}
+
+// Mirrors of the gphoto2 package's structs, as of github.com/jonmol/gphoto2
+// v1.0.1 (pinned in go.mod). The library only knows how to open the first
+// available camera, so we initialize the C handle ourselves and hand it over
+// to a gphoto2.Camera.
+type gphotoCamera struct {
+	gpCamera *C.Camera
+	Ctx      *gphoto2.Context
+	Settings *gphoto2.CameraWidget
+}
+type gphotoContext struct {
+	gpContext *C.GPContext
+}
+
+// The build fails (constant overflow) when an upgrade of gphoto2 changes the
+// layout of the mirrored structs, they must then be updated.
+var (
+	_ [unsafe.Sizeof(gphoto2.Camera{}) - unsafe.Sizeof(gphotoCamera{})]byte
+	_ [unsafe.Sizeof(gphotoCamera{}) - unsafe.Sizeof(gphoto2.Camera{})]byte
+	_ [unsafe.Offsetof(gphoto2.Camera{}.Ctx) - unsafe.Offsetof(gphotoCamera{}.Ctx)]byte
+	_ [unsafe.Offsetof(gphotoCamera{}.Ctx) - unsafe.Offsetof(gphoto2.Camera{}.Ctx)]byte
+	_ [unsafe.Offsetof(gphoto2.Camera{}.Settings) - unsafe.Offsetof(gphotoCamera{}.Settings)]byte
+	_ [unsafe.Offsetof(gphotoCamera{}.Settings) - unsafe.Offsetof(gphoto2.Camera{}.Settings)]byte
+	_ [unsafe.Sizeof(gphoto2.Context{}) - unsafe.Sizeof(gphotoContext{})]byte
+	_ [unsafe.Sizeof(gphotoContext{}) - unsafe.Sizeof(gphoto2.Context{})]byte
+)
+
+// openDevice connects to the camera at the device's port.
+func openDevice(d Device) (*gphoto2.Camera, error) {
+	ctx, err := gphoto2.NewContext()
+	if err != nil {
+		return nil, err
+	}
+	gpContext := (*gphotoContext)(unsafe.Pointer(ctx)).gpContext
+
+	model := C.CString(d.Model)
+	defer C.free(unsafe.Pointer(model))
+	port := C.CString(d.Port)
+	defer C.free(unsafe.Pointer(port))
+
+	var gpCamera *C.Camera
+	if res := C.open_camera_on_port(&gpCamera, gpContext, model, port); res < C.GP_OK {
+		C.gp_context_unref(gpContext)
+		return nil, gphotoError(res)
+	}
+
+	cam := &gphoto2.Camera{Ctx: ctx}
+	(*gphotoCamera)(unsafe.Pointer(cam)).gpCamera = gpCamera
+	return cam, nil
+}
+
+// gphoto2 reports USB cameras as `usb:BUS,DEV`, sysfs is the only place
+// where we can map this back to the device's iSerial.
+func usbSerialFromPort(port string) string {
+	busDev, found := strings.CutPrefix(port, "usb:")
+	if !found {
+		return ""
+	}
+	bus, dev, found := strings.Cut(busDev, ",")
+	if !found {
+		return ""
+	}
+	busNum, err1 := strconv.Atoi(bus)
+	devNum, err2 := strconv.Atoi(dev)
+	if err1 != nil || err2 != nil {
+		return ""
+	}
+
+	dirs, _ := filepath.Glob("/sys/bus/usb/devices/*")
+	for _, dir := range dirs {
+		if readSysfsInt(dir, "busnum") == busNum && readSysfsInt(dir, "devnum") == devNum {
+			serial, _ := os.ReadFile(filepath.Join(dir, "serial"))
+			return strings.TrimSpace(string(serial))
+		}
+	}
+	return ""
+}
+
+func readSysfsInt(dir string, name string) int {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return -1
+	}
+	return n
+}
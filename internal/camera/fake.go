@@ -0,0 +1,143 @@
+package camera
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+var errFakeCameraSettings = errors.New("the fake camera has no settings")
+
+// FakeCamera stands in for the DSLR when replaying or simulating a print, it
+// saves generated pictures where the real camera would save its snaps.
+type FakeCamera struct {
+	mu                         sync.Mutex
+	status                     CameraStatus
+	currentSnapshotDirFullPath string
+	baseOutputDir              string
+	frames                     int
+}
+
+func MakeFakeCamera(baseOutputDir string) *FakeCamera {
+	if err := utils.CreateDirectoryIfNotExists(baseOutputDir); err != nil {
+		log.Fatal("Output directory does not exists, and we cannot create it:", baseOutputDir)
+	}
+	return &FakeCamera{
+		baseOutputDir: baseOutputDir,
+		status:        CameraStatus{State: StateDisconnected, Since: time.Now()},
+	}
+}
+
+var fakeDevice = Device{Model: "Fake camera", Port: "fake:"}
+
+func (c *FakeCamera) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = CameraStatus{State: StateConnected, Device: fakeDevice, Since: time.Now()}
+	log.Println("Started FakeCamera")
+}
+
+func (c *FakeCamera) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = CameraStatus{State: StateDisconnected, Since: time.Now()}
+	log.Println("Stopped FakeCamera")
+}
+
+func (c *FakeCamera) Disconnect() {
+	c.Stop()
+}
+
+func (c *FakeCamera) Snap() (string, error) {
+	currentSnapshotDir := c.GetCurrentSnapshotsDir()
+	if err := utils.CreateDirectoryIfNotExists(currentSnapshotDir); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	outcome := CaptureOutcome{Time: time.Now(), Attempts: 1}
+	defer func() {
+		if err := recordCaptureOutcome(currentSnapshotDir, outcome); err != nil {
+			log.Println("Cannot record capture outcome", err)
+		}
+	}()
+
+	if c.status.State != StateConnected {
+		outcome.Error = ErrNoCamera.Error()
+		return "", ErrNoCamera
+	}
+
+	c.frames++
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, fakeFrame(c.frames), nil); err != nil {
+		outcome.Error = err.Error()
+		return "", err
+	}
+	path, err := snapPath(currentSnapshotDir)
+	if err != nil {
+		outcome.Error = err.Error()
+		return "", err
+	}
+	if err := utils.WriteFileAtomically(path, buf.Bytes()); err != nil {
+		outcome.Error = err.Error()
+		return "", err
+	}
+	outcome.FileName = filepath.Base(path)
+	return path, nil
+}
+
+// A small gray picture, a bit lighter with each frame.
+func fakeFrame(n int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 320, 240))
+	shade := color.Gray{Y: uint8(n * 8 % 256)}
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			img.SetGray(x, y, shade)
+		}
+	}
+	return img
+}
+
+func (c *FakeCamera) CreateNewSnapshotsDir() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.currentSnapshotDirFullPath = utils.CreateNewPhotoDirectory(c.baseOutputDir)
+	log.Println("Created new Snapshot directory: " + c.currentSnapshotDirFullPath)
+}
+
+func (c *FakeCamera) GetCurrentSnapshotsDir() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.currentSnapshotDirFullPath) == 0 {
+		return c.baseOutputDir + "/orphans"
+	}
+	return c.currentSnapshotDirFullPath
+}
+
+func (c *FakeCamera) GetDevice() Device {
+	return c.GetStatus().Device
+}
+
+func (c *FakeCamera) GetStatus() CameraStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func (c *FakeCamera) GetSettings() ([]Setting, error) {
+	return nil, errFakeCameraSettings
+}
+
+func (c *FakeCamera) SetSetting(name string, value string) error {
+	return errFakeCameraSettings
+}
@@ -7,27 +7,50 @@ import (
 	"github.com/rubiojr/go-usbmon"
 )
 
-func MonitorCameraUsbEvents(cameraSerialNumber *string, cameraWrapper *CameraWrapper) {
+// Matches the "add" events of the device with the given serial number, and
+// the "remove" events of the last such device that was added.
+// "remove" events do not carry the serial number (see the design log below)
+// so we remember the sysfs path of the device when it is added.
+type cameraEventsFilter struct {
+	serial  string
+	devPath string
+}
+
+func (f *cameraEventsFilter) Matches(dev *usbmon.Device) bool {
+	switch usbmon.ActionEvent(dev.Action()) {
+	case usbmon.ActionAdd:
+		if dev.Serial() != f.serial {
+			return false
+		}
+		f.devPath = dev.Path()
+		return true
+	case usbmon.ActionRemove:
+		return len(f.devPath) > 0 && dev.Path() == f.devPath
+	default:
+		return false
+	}
+}
 
-	actionFilter := &usbmon.ActionFilter{Action: usbmon.ActionAdd}
-	serialFilter := &usbmon.SerialFilter{Serial: *cameraSerialNumber}
+func MonitorCameraUsbEvents(cameraSerialNumber *string, cameraWrapper CameraWrapperInterface) {
 
 	devs, err := usbmon.ListenFiltered(
 		context.Background(),
-		actionFilter,
-		serialFilter,
+		&cameraEventsFilter{serial: *cameraSerialNumber},
 	)
 
 	if err != nil {
 		panic(err)
 	}
 
-    log.Println("Monitoring camera with ID" + *cameraSerialNumber + "...")
+	log.Println("Monitoring camera with ID" + *cameraSerialNumber + "...")
 	for dev := range devs {
 		switch dev.Action() {
 		case "add":
-			log.Println("Camera connected")
+			log.Println("Camera connected at", dev.Path())
 			cameraWrapper.Start()
+		case "remove":
+			log.Println("Camera disconnected from", dev.Path())
+			cameraWrapper.Disconnect()
 		}
 	}
 }
@@ -41,4 +64,10 @@ event does not have a vendor/serial attached to it (only path).
 It's fine though, we really only care about when the camera connects, at which
 point we need to refresh the gPhoto handle, and restarting the cameraWrapper
 instance will clear the previous instance.
+
+## 2026-10-18
+We do care about the "remove" event after all: when the camera powers off
+mid-print we kept a dead gPhoto handle around until the next capture failed.
+The "add" event gives us the device path, which is all the "remove" event
+has, so the filter remembers it to recognize the removal of our camera.
 */
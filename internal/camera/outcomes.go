@@ -0,0 +1,86 @@
+package camera
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the file, in each snapshots directory, where the outcome of every
+// capture is appended as a JSON line.
+const CapturesLogFileName = "captures.jsonl"
+
+type CaptureOutcome struct {
+	Time      time.Time
+	FileName  string `json:",omitempty"`
+	Attempts  int
+	LatencyMs int64
+	Error     string `json:",omitempty"`
+}
+
+func (o CaptureOutcome) Failed() bool {
+	return len(o.Error) > 0
+}
+
+type CaptureStats struct {
+	Total  uint
+	Failed uint
+}
+
+func recordCaptureOutcome(snapshotsDir string, outcome CaptureOutcome) error {
+	f, err := os.OpenFile(
+		filepath.Join(snapshotsDir, CapturesLogFileName),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(outcome)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Returns every recorded capture outcome of a snapshots directory, oldest
+// first. Folders created before outcomes were recorded have none.
+func ReadCaptureOutcomes(snapshotsDir string) ([]CaptureOutcome, error) {
+	f, err := os.Open(filepath.Join(snapshotsDir, CapturesLogFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var outcomes []CaptureOutcome
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var o CaptureOutcome
+		// A truncated last line (e.g. power loss) should not hide the rest
+		if err := json.Unmarshal(scanner.Bytes(), &o); err == nil {
+			outcomes = append(outcomes, o)
+		}
+	}
+	return outcomes, scanner.Err()
+}
+
+func GetCaptureStats(snapshotsDir string) CaptureStats {
+	outcomes, _ := ReadCaptureOutcomes(snapshotsDir)
+	stats := CaptureStats{Total: uint(len(outcomes))}
+	for _, o := range outcomes {
+		if o.Failed() {
+			stats.Failed++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,122 @@
+package camera
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+
+	"github.com/jonmol/gphoto2"
+)
+
+// Setting is a flattened view of a gphoto2 config widget.
+type Setting struct {
+	Group    string
+	Name     string
+	Label    string
+	Type     string
+	Value    string
+	Options  []string
+	ReadOnly bool
+}
+
+// Applies the settings from the config, in alphabetical order so that the
+// outcome does not depend on map iteration.
+// Failing to apply a setting is not fatal, we still want pictures.
+func applySettings(cam *gphoto2.Camera, settings map[string]string) {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if err := setSetting(cam, name, settings[name]); err != nil {
+			log.Printf("Cannot set camera setting %s to %q: %v\n", name, settings[name], err)
+		} else {
+			log.Printf("Camera setting %s set to %q\n", name, settings[name])
+		}
+	}
+}
+
+func setSetting(cam *gphoto2.Camera, name string, value string) error {
+	w, err := cam.GetSetting(name)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		return fmt.Errorf("unknown camera setting %q", name)
+	}
+
+	switch w.Type() {
+	case gphoto2.WidgetToggle:
+		on, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		return w.Set(on)
+	case gphoto2.WidgetRadio, gphoto2.WidgetMenu, gphoto2.WidgetText:
+		return w.Set(value)
+	default:
+		return fmt.Errorf("setting %q has unsupported type %s", name, w.Type())
+	}
+}
+
+func parseToggle(value string) (bool, error) {
+	switch value {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	default:
+		return strconv.ParseBool(value)
+	}
+}
+
+// Reloads the widget tree from the camera and returns every leaf widget.
+func listSettings(cam *gphoto2.Camera) ([]Setting, error) {
+	if err := cam.LoadWidgets(); err != nil {
+		return nil, err
+	}
+	var settings []Setting
+	collectSettings(cam.Settings, "", &settings)
+	return settings, nil
+}
+
+func collectSettings(w *gphoto2.CameraWidget, group string, settings *[]Setting) {
+	if w == nil {
+		return
+	}
+	switch w.Type() {
+	case gphoto2.WidgetWindow, gphoto2.WidgetSection:
+		for _, child := range w.Children() {
+			collectSettings(child, w.Label(), settings)
+		}
+		return
+	}
+
+	s := Setting{
+		Group:    group,
+		Name:     w.Name(),
+		Label:    w.Label(),
+		Type:     string(w.Type()),
+		ReadOnly: w.ReadOnly(),
+	}
+	if v, err := w.Get(); err == nil && v != nil {
+		if on, isToggle := v.(bool); isToggle {
+			s.Value = map[bool]string{true: "on", false: "off"}[on]
+		} else {
+			s.Value = fmt.Sprint(v)
+		}
+	}
+	if opts, err := w.Options(); err == nil {
+		s.Options = opts
+	}
+	// Only these types can be written back through the gphoto2 package
+	switch w.Type() {
+	case gphoto2.WidgetToggle, gphoto2.WidgetRadio, gphoto2.WidgetMenu, gphoto2.WidgetText:
+	default:
+		s.ReadOnly = true
+	}
+	*settings = append(*settings, s)
+}
@@ -0,0 +1,59 @@
+package camera
+
+import (
+	"log"
+	"time"
+)
+
+type State string
+
+const (
+	// A gphoto2 handle is open and idle
+	StateConnected State = "connected"
+	// There is no gphoto2 handle, either because the camera is not plugged
+	// in or because it was stopped at the end of a print
+	StateDisconnected State = "disconnected"
+	// The camera is plugged in but the last operation on it failed
+	StateError State = "error"
+	// A capture is in progress
+	StateBusy State = "busy"
+)
+
+type CameraStatus struct {
+	State  State
+	Device Device
+	Error  string `json:",omitempty"`
+	// When the camera entered this state
+	Since time.Time
+}
+
+func (c *CameraWrapper) GetStatus() CameraStatus {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.status
+}
+
+// Returns the camera currently in use, the zero value means there is none.
+func (c *CameraWrapper) GetDevice() Device {
+	return c.GetStatus().Device
+}
+
+func (c *CameraWrapper) setStatus(state State, device Device, err error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.status.State != state {
+		log.Printf("Camera is now %s\n", state)
+		c.status.Since = time.Now()
+	}
+	c.status.State = state
+	c.status.Device = device
+	c.status.Error = ""
+	if err != nil {
+		c.status.Error = err.Error()
+	}
+}
+
+// Same as setStatus, keeping the current device.
+func (c *CameraWrapper) setState(state State, err error) {
+	c.setStatus(state, c.GetDevice(), err)
+}
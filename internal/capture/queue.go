@@ -0,0 +1,49 @@
+package capture
+
+import "sync"
+
+// A FIFO which is never full, for what must not be dropped. It has a single
+// consumer.
+type queue[T any] struct {
+	mu    sync.Mutex
+	items []T
+	// Signaled after a push, the consumer may have missed it
+	ready chan struct{}
+}
+
+func newQueue[T any]() *queue[T] {
+	return &queue[T]{ready: make(chan struct{}, 1)}
+}
+
+func (q *queue[T]) push(item T) {
+	q.mu.Lock()
+	q.items = append(q.items, item)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Blocks until there is an item.
+func (q *queue[T]) pop() T {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			var zero T
+			q.items[0] = zero
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return item
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
+
+func (q *queue[T]) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
@@ -0,0 +1,184 @@
+package capture
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/camera"
+)
+
+var ErrQueueFull = errors.New("capture queue is full")
+
+// Result of a capture, handed to the post-processors.
+type Result struct {
+	// When the capture command was received from the printer
+	ReceivedAt time.Time
+	// When the camera actually started capturing
+	StartedAt time.Time
+	// How long the camera took, retries included
+	Duration time.Duration
+	// Parameters of the capture action, e.g. `layer` and `z` for
+	// `action:capture layer=3 z=0.80`
+	Params   map[string]string
+	SnapPath string
+	Err      error
+}
+
+// Time spent waiting in the queue before the capture started.
+func (r Result) QueueDelay() time.Duration {
+	return r.StartedAt.Sub(r.ReceivedAt)
+}
+
+type PostProcessor func(r Result)
+
+type job struct {
+	receivedAt time.Time
+	params     map[string]string
+	// Called on the worker goroutine once the capture is done
+	onDone func(r Result)
+	// Either a capture (nil) or an arbitrary camera operation which needs to
+	// happen in order with the captures (start/stop of a print).
+	fn func()
+}
+
+// Worker takes the pictures off the serial goroutine.
+// Captures and camera operations are run one at a time in the order they
+// were queued, post-processing happens on yet another goroutine so that it
+// never delays the next capture. Every frame is post-processed, except by
+// the optional post-processors which skip frames when they fall behind.
+type Worker struct {
+	cam  camera.CameraWrapperInterface
+	jobs *queue[job]
+	// Captures are dropped above `queueSize`, camera operations never are
+	queueSize      int
+	mu             sync.Mutex
+	queuedCaptures int
+	results        *queue[Result]
+	postProcessors []PostProcessor
+	optional       chan Result
+	// See AddOptionalPostProcessors
+	optionalPostProcessors []PostProcessor
+	overruns               atomic.Uint64
+	// Queued jobs which are not done yet
+	pending sync.WaitGroup
+}
+
+func NewWorker(cam camera.CameraWrapperInterface, queueSize int, postProcessors ...PostProcessor) *Worker {
+	return &Worker{
+		cam:            cam,
+		jobs:           newQueue[job](),
+		queueSize:      queueSize,
+		results:        newQueue[Result](),
+		postProcessors: postProcessors,
+		optional:       make(chan Result, queueSize),
+	}
+}
+
+// Adds post-processors which only save time later on (e.g. thumbnails), a
+// frame skips them when `queueSize` frames are already waiting for them.
+// They run after the other post-processors. Must be called before Start.
+func (w *Worker) AddOptionalPostProcessors(postProcessors ...PostProcessor) {
+	w.optionalPostProcessors = append(w.optionalPostProcessors, postProcessors...)
+}
+
+func (w *Worker) Start() {
+	go w.captureLoop()
+	go w.postProcessLoop()
+	if len(w.optionalPostProcessors) > 0 {
+		go w.optionalPostProcessLoop()
+	}
+}
+
+// Queues a capture without ever blocking, when the queue is full the capture
+// is dropped and counted as an overrun.
+// `onDone` is optional, it is called as soon as the picture is saved, before
+// post-processing, and must not block.
+func (w *Worker) EnqueueCapture(receivedAt time.Time, params map[string]string, onDone func(r Result)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.queuedCaptures >= w.queueSize {
+		n := w.overruns.Add(1)
+		log.Printf("Capture queue is full, dropping capture (%d dropped so far)\n", n)
+		return ErrQueueFull
+	}
+	w.queuedCaptures++
+	w.pending.Add(1)
+	w.jobs.push(job{receivedAt: receivedAt, params: params, onDone: onDone})
+	return nil
+}
+
+// Queues a camera operation after the pending captures.
+// Like captures it is called from the serial goroutine, so it never blocks
+// either, but it is never dropped: the start and stop of a print must happen
+// however late the camera is.
+func (w *Worker) Do(fn func()) {
+	w.pending.Add(1)
+	w.jobs.push(job{receivedAt: time.Now(), fn: fn})
+}
+
+// Blocks until every queued job is done, post-processing excluded.
+// Nothing must be queued meanwhile.
+func (w *Worker) Wait() {
+	w.pending.Wait()
+}
+
+// Number of captures dropped because the queue was full.
+func (w *Worker) Overruns() uint64 {
+	return w.overruns.Load()
+}
+
+func (w *Worker) captureLoop() {
+	for {
+		j := w.jobs.pop()
+		if j.fn != nil {
+			j.fn()
+			w.pending.Done()
+			continue
+		}
+
+		w.mu.Lock()
+		w.queuedCaptures--
+		w.mu.Unlock()
+
+		r := Result{ReceivedAt: j.receivedAt, StartedAt: time.Now(), Params: j.params}
+		r.SnapPath, r.Err = w.cam.Snap()
+		r.Duration = time.Since(r.StartedAt)
+		log.Printf("Capture done in %s (queued for %s)\n", r.Duration, r.QueueDelay())
+		if j.onDone != nil {
+			j.onDone(r)
+		}
+		w.pending.Done()
+
+		if len(w.postProcessors) > 0 || len(w.optionalPostProcessors) > 0 {
+			w.results.push(r)
+		}
+	}
+}
+
+func (w *Worker) postProcessLoop() {
+	for {
+		r := w.results.pop()
+		for _, p := range w.postProcessors {
+			p(r)
+		}
+		if len(w.optionalPostProcessors) == 0 {
+			continue
+		}
+		select {
+		case w.optional <- r:
+		default:
+			log.Println("Optional post-processing is behind, skipping it for", r.SnapPath)
+		}
+	}
+}
+
+func (w *Worker) optionalPostProcessLoop() {
+	for r := range w.optional {
+		for _, p := range w.optionalPostProcessors {
+			p(r)
+		}
+	}
+}
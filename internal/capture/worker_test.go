@@ -0,0 +1,279 @@
+package capture
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/camera"
+)
+
+// A fake camera whose captures wait until they are released.
+type blockingCamera struct {
+	*camera.FakeCamera
+	release chan struct{}
+}
+
+func (c *blockingCamera) Snap() (string, error) {
+	<-c.release
+	return c.FakeCamera.Snap()
+}
+
+func makeBlockingCamera(t *testing.T) *blockingCamera {
+	cam := camera.MakeFakeCamera(t.TempDir())
+	cam.Start()
+	cam.CreateNewSnapshotsDir()
+	return &blockingCamera{FakeCamera: cam, release: make(chan struct{})}
+}
+
+func TestCapturesAreDoneInOrder(t *testing.T) {
+	cam := camera.MakeFakeCamera(t.TempDir())
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	w := NewWorker(cam, 8)
+	w.Start()
+	w.Do(func() {
+		cam.Start()
+		record("start")
+	})
+	for i := 0; i < 3; i++ {
+		err := w.EnqueueCapture(time.Now(), nil, func(r Result) {
+			if r.Err != nil {
+				t.Error("capture failed:", r.Err)
+			}
+			record("capture")
+		})
+		if err != nil {
+			t.Fatal("cannot queue capture:", err)
+		}
+	}
+	w.Do(func() {
+		cam.Stop()
+		record("stop")
+	})
+	w.Wait()
+
+	want := []string{"start", "capture", "capture", "capture", "stop"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != len(want) {
+		t.Fatalf("events are %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events are %v, want %v", events, want)
+		}
+	}
+}
+
+func TestOverruns(t *testing.T) {
+	cam := makeBlockingCamera(t)
+	w := NewWorker(cam, 2)
+	w.Start()
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	captured := make(chan Result, 8)
+	onDone := func(r Result) {
+		captured <- r
+		record("capture")
+	}
+
+	// The first capture is taken off the queue and blocks the worker
+	if err := w.EnqueueCapture(time.Now(), nil, onDone); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return w.jobs.len() == 0 })
+
+	// Then the queue fills up
+	for i := 0; i < 2; i++ {
+		if err := w.EnqueueCapture(time.Now(), nil, onDone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.EnqueueCapture(time.Now(), nil, onDone); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("EnqueueCapture returned %v on a full queue, want %v", err, ErrQueueFull)
+	}
+	// The end of the print is never dropped, nor the next one
+	w.Do(func() { record("stop") })
+	w.Do(func() { record("start") })
+	if n := w.Overruns(); n != 1 {
+		t.Errorf("%d overruns, want 1", n)
+	}
+
+	close(cam.release)
+	w.Wait()
+	if len(captured) != 3 {
+		t.Errorf("%d captures done, want 3", len(captured))
+	}
+	for len(captured) > 0 {
+		r := <-captured
+		if r.Err != nil {
+			t.Error("capture failed:", r.Err)
+		}
+		if r.QueueDelay() < 0 {
+			t.Errorf("negative queue delay %s", r.QueueDelay())
+		}
+	}
+	want := []string{"capture", "capture", "capture", "stop", "start"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(events, want) {
+		t.Errorf("events are %v, want %v", events, want)
+	}
+}
+
+func TestDoNeverBlocks(t *testing.T) {
+	cam := makeBlockingCamera(t)
+	w := NewWorker(cam, 1)
+	w.Start()
+	w.EnqueueCapture(time.Now(), nil, nil)
+	waitFor(t, func() bool { return w.jobs.len() == 0 })
+	w.EnqueueCapture(time.Now(), nil, nil)
+
+	ran := make(chan struct{})
+	queued := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			w.Do(func() { ran <- struct{}{} })
+		}
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("Do blocked on a full queue")
+	}
+
+	close(cam.release)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatalf("%d camera operations ran, want 3", i)
+		}
+	}
+}
+
+func TestOptionalPostProcessorsBehind(t *testing.T) {
+	cam := camera.MakeFakeCamera(t.TempDir())
+	cam.Start()
+	cam.CreateNewSnapshotsDir()
+
+	var mu sync.Mutex
+	var processed, optional []string
+	w := NewWorker(cam, 1, func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, r.SnapPath)
+	})
+	started, release := make(chan struct{}, 8), make(chan struct{})
+	w.AddOptionalPostProcessors(func(r Result) {
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		optional = append(optional, r.SnapPath)
+	})
+	w.Start()
+
+	// One is being post-processed by the optional post-processor, one waits
+	// for it, the others skip it, but the captures themselves are never
+	// delayed and every frame goes through the other post-processors
+	const captures = 5
+	for i := 0; i < captures; i++ {
+		done := make(chan Result, 1)
+		if err := w.EnqueueCapture(time.Now(), nil, func(r Result) { done <- r }); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-done:
+			if r.Err != nil {
+				t.Fatal("capture failed:", r.Err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("capture delayed by post-processing")
+		}
+		if i == 0 {
+			<-started
+		}
+	}
+	w.Wait()
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(processed) == captures
+	})
+	close(release)
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(optional) == 2
+	})
+	stats := camera.GetCaptureStats(cam.GetCurrentSnapshotsDir())
+	if stats.Total != captures || stats.Failed != 0 {
+		t.Errorf("stats are %+v, want %d successful captures", stats, captures)
+	}
+}
+
+func TestEveryFrameIsPostProcessed(t *testing.T) {
+	cam := camera.MakeFakeCamera(t.TempDir())
+	cam.Start()
+	cam.CreateNewSnapshotsDir()
+
+	release := make(chan struct{})
+	processed := make(chan string, 16)
+	w := NewWorker(cam, 1, func(r Result) {
+		<-release
+		processed <- r.SnapPath
+	})
+	w.Start()
+
+	// The post-processor is far behind the captures
+	const captures = 5
+	var snapPaths []string
+	for i := 0; i < captures; i++ {
+		done := make(chan Result, 1)
+		if err := w.EnqueueCapture(time.Now(), nil, func(r Result) { done <- r }); err != nil {
+			t.Fatal(err)
+		}
+		snapPaths = append(snapPaths, (<-done).SnapPath)
+	}
+	close(release)
+
+	for i, want := range snapPaths {
+		select {
+		case snapPath := <-processed:
+			if snapPath != want {
+				t.Errorf("frame %d is %s, want %s", i, snapPath, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d frames post-processed, want %d", i, captures)
+		}
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -9,18 +13,94 @@ import (
 type Printer struct {
 	PortName string
 	BaudRate int
+	// USB vendor ID and serial number of the printer, when set the port is
+	// looked up through udev and PortName is ignored.
+	VendorID     string
+	SerialNumber string
+	// Show the progress of the timelapse on the printer's display (`M117`)
+	StatusMessages bool
+	// The G-code waits after each `action:capture` until we resume the
+	// printer, which we do as soon as the picture is taken
+	CaptureHandshake bool
+	// The printer is resumed after this long even if the capture is not done
+	HandshakeTimeoutInSeconds int
+	// Sent to resume the printer, it must be handled by the firmware while
+	// it is waiting (emergency parser)
+	ResumeCommand string
+	// Everything read from the port is appended to this file when set, see
+	// the `replay` command
+	RecordPath string
+}
+
+func (p *Printer) WithDefaults() Printer {
+	var conf Printer = *p
+	if conf.HandshakeTimeoutInSeconds <= 0 {
+		conf.HandshakeTimeoutInSeconds = 10
+	}
+
+	if len(conf.ResumeCommand) == 0 {
+		conf.ResumeCommand = "M108"
+	}
+
+	return conf
 }
 
 type Camera struct {
 	CameraSerialNumber string
-	OutputDir          string
-	LiveFeedURL        string
+	// gphoto2 port path (e.g. `usb:001,005`), takes precedence over the
+	// serial number when set.
+	CameraPort string
+	// gphoto2 model name (e.g. `Canon EOS 600D`), only used when neither
+	// the port nor the serial number are set.
+	CameraModel string
+	OutputDir   string
+	LiveFeedURL string
+	// gphoto2 config widgets applied when the camera starts, keyed by
+	// widget name (e.g. `iso`, `shutterspeed`, `whitebalance`).
+	Settings map[string]string
+	// A capture taking longer than this is considered failed
+	SnapTimeoutInSeconds int
+	// How many times a failed capture is tried, including the first one
+	SnapAttempts int
+	// How many capture requests can be pending before new ones are dropped
+	CaptureQueueSize int
+	// Also write the metadata of each frame (layer, Z, temperatures...) in
+	// the picture's XMP, as `exif:UserComment`
+	EmbedFrameMetadata bool
+}
+
+func (c *Camera) WithDefaults() Camera {
+	var conf Camera = *c
+	if conf.SnapTimeoutInSeconds <= 0 {
+		conf.SnapTimeoutInSeconds = 15
+	}
+
+	if conf.SnapAttempts <= 0 {
+		conf.SnapAttempts = 3
+	}
+
+	if conf.CaptureQueueSize <= 0 {
+		conf.CaptureQueueSize = 8
+	}
+
+	return conf
 }
 
 type Web struct {
 	ThumbnailCreationMaxGoroutines int
 	PrusaLinkKey                   string
 	PrinterUrl                     string
+	// Where thumbnails and previews are kept, defaults to `.thumbnails` in
+	// the camera's OutputDir
+	ThumbnailCacheDir string
+	// Width of the thumbnails in the folder view, 360 by default
+	ThumbnailWidth int
+	// Width of the previews shown in the modal, 1280 by default
+	PreviewWidth int
+	// What creates the thumbnails and previews: "vips" (default) or "go",
+	// which is slower but works without libvips (the default when built
+	// with the `novips` tag)
+	ThumbnailBackend string
 }
 
 type FFMPEG struct {
@@ -29,6 +109,109 @@ type FFMPEG struct {
 	Codec                 string
 	PixelFormat           string
 	TimeoutInMinutes      int
+	// Optional, see Stabilization
+	Stabilization *Stabilization
+	// Optional, see Deflicker
+	Deflicker *Deflicker
+	// Part of the frames which is kept in the video, as fractions of their
+	// size: x, y, width, height. The whole frames when omitted.
+	Crop Numbers
+	// Optional, see Overlay
+	Overlay *Overlay
+	// Additional videos rendered from the same frames (e.g. a short one to
+	// share), keyed by name. Unset fields are taken from the section above.
+	Profiles map[string]RenderProfile
+}
+
+// How a video is rendered from the frames of a print.
+type RenderProfile struct {
+	// Set from the key in `FFMPEG.Profiles`, "default" for the main video
+	Name                  string `toml:"-"`
+	OutputVideoResolution string
+	FramesPerSecond       string
+	Codec                 string
+	PixelFormat           string
+	Stabilization         *Stabilization
+	Deflicker             *Deflicker
+	Crop                  Numbers
+	Overlay               *Overlay
+}
+
+// Removes the camera shake from the video with ffmpeg's vidstab filters,
+// which need ffmpeg to be built with libvidstab. The video is zoomed in so
+// that the borders moved in by the stabilization are never shown.
+type Stabilization struct {
+	Enabled bool
+	// How shaky the camera is, from 1 to 10, 5 by default
+	Shakiness int
+	// Every frame is aligned to the first one when 0 (the default, for a
+	// camera which should not move at all), otherwise the camera motion is
+	// smoothed over this many frames before and after each one
+	Smoothing int
+}
+
+func (s *Stabilization) WithDefaults() Stabilization {
+	var conf Stabilization = *s
+	if conf.Shakiness <= 0 {
+		conf.Shakiness = 5
+	}
+
+	if conf.Smoothing < 0 {
+		conf.Smoothing = 0
+	}
+
+	return conf
+}
+
+// Evens out the brightness of the frames with ffmpeg's deflicker filter,
+// for auto-exposure and room lighting changes.
+type Deflicker struct {
+	Enabled bool
+	// How many frames the brightness is averaged over, from 2 to 129, 15 by
+	// default
+	Window int
+	// How it is averaged, one of ffmpeg's deflicker modes: `am`, `gm`,
+	// `hm`, `qm`, `cm`, `pm` (the default) or `median`
+	Mode string
+}
+
+func (d *Deflicker) WithDefaults() Deflicker {
+	var conf Deflicker = *d
+	if conf.Window <= 0 {
+		conf.Window = 15
+	}
+	conf.Window = min(max(conf.Window, 2), 129)
+
+	if len(conf.Mode) == 0 {
+		conf.Mode = "pm"
+	}
+
+	return conf
+}
+
+// What is written on the video, from the metadata of each frame.
+type Overlay struct {
+	Enabled bool
+	// Among OVERLAY_FIELDS, all of them by default
+	Fields []string
+	// A bar at the bottom of the video showing the progress of the print
+	ProgressBar bool
+	// Font of the text, fontconfig's default font when omitted
+	FontFile string
+	// Image (e.g. a PNG logo) shown in the top right corner
+	Watermark string
+}
+
+// The fields of an overlay, in the order they are written.
+var OVERLAY_FIELDS = []string{"job", "layer", "z", "elapsed", "clock"}
+
+func (o *Overlay) WithDefaults() Overlay {
+	var conf Overlay = *o
+	if len(conf.Fields) == 0 {
+		conf.Fields = OVERLAY_FIELDS
+	}
+
+	return conf
 }
 
 func (f *FFMPEG) WithDefaults() FFMPEG {
@@ -49,14 +232,177 @@ func (f *FFMPEG) WithDefaults() FFMPEG {
 		conf.PixelFormat = "yuv420p"
 	}
 
+	if conf.TimeoutInMinutes <= 0 {
+		conf.TimeoutInMinutes = 20
+	}
+
+	return conf
+}
+
+// The main video's profile first, then the additional ones by name.
+func (f *FFMPEG) RenderProfiles() []RenderProfile {
+	conf := f.WithDefaults()
+	profiles := []RenderProfile{{
+		Name:                  DEFAULT_RENDER_PROFILE,
+		OutputVideoResolution: conf.OutputVideoResolution,
+		FramesPerSecond:       conf.FramesPerSecond,
+		Codec:                 conf.Codec,
+		PixelFormat:           conf.PixelFormat,
+		Stabilization:         conf.Stabilization,
+		Deflicker:             conf.Deflicker,
+		Crop:                  conf.Crop,
+		Overlay:               conf.Overlay,
+	}}
+
+	names := make([]string, 0, len(conf.Profiles))
+	for name := range conf.Profiles {
+		if name != DEFAULT_RENDER_PROFILE {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		profile := conf.Profiles[name]
+		profile.Name = name
+		if len(profile.OutputVideoResolution) == 0 {
+			profile.OutputVideoResolution = conf.OutputVideoResolution
+		}
+		if len(profile.FramesPerSecond) == 0 {
+			profile.FramesPerSecond = conf.FramesPerSecond
+		}
+		if len(profile.Codec) == 0 {
+			profile.Codec = conf.Codec
+		}
+		if len(profile.PixelFormat) == 0 {
+			profile.PixelFormat = conf.PixelFormat
+		}
+		if profile.Stabilization == nil {
+			profile.Stabilization = conf.Stabilization
+		}
+		if profile.Deflicker == nil {
+			profile.Deflicker = conf.Deflicker
+		}
+		if profile.Crop == nil {
+			profile.Crop = conf.Crop
+		}
+		if profile.Overlay == nil {
+			profile.Overlay = conf.Overlay
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+const DEFAULT_RENDER_PROFILE = "default"
+
+// FramesPerSecond as a number, ffmpeg accepts rationals (`30000/1001`) as
+// well as numbers.
+func (p RenderProfile) FrameRate() (float64, error) {
+	num, den, isRational := strings.Cut(p.FramesPerSecond, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, err
+	}
+	d := 1.0
+	if isRational {
+		if d, err = strconv.ParseFloat(den, 64); err != nil {
+			return 0, err
+		}
+	}
+	if n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("invalid frame rate %q", p.FramesPerSecond)
+	}
+	return n / d, nil
+}
+
+// A float which can also be written as an integer in the config, e.g. `40`
+// rather than `40.0`.
+type Number float64
+
+func (n *Number) UnmarshalTOML(data interface{}) error {
+	switch value := data.(type) {
+	case int64:
+		*n = Number(value)
+	case float64:
+		*n = Number(value)
+	default:
+		return fmt.Errorf("%v is not a number", data)
+	}
+	return nil
+}
+
+// Floats which can also be written as integers, e.g. `[0, 0, 1, 1]`. TOML
+// arrays cannot mix both though, `[0, 0, 1, 0.5]` must be written
+// `[0.0, 0.0, 1.0, 0.5]`.
+type Numbers []float64
+
+func (n *Numbers) UnmarshalTOML(data interface{}) error {
+	values, ok := data.([]interface{})
+	if !ok {
+		return fmt.Errorf("%v is not an array", data)
+	}
+	*n = make(Numbers, len(values))
+	for i, value := range values {
+		var number Number
+		if err := number.UnmarshalTOML(value); err != nil {
+			return err
+		}
+		(*n)[i] = float64(number)
+	}
+	return nil
+}
+
+// Checks made on each frame after it is captured, to find the blurry ones
+// and the ones with something in the way.
+type FrameAnalysis struct {
+	Enabled bool
+	// Part of the frame which is looked at, as fractions of its size:
+	// x, y, width, height. The whole frame when omitted.
+	Region Numbers
+	// A frame is blurry when its sharpness is below this fraction of the
+	// median sharpness of the previous frames
+	BlurThreshold Number
+	// A frame is too dark or too bright when its brightness (0 to 255) is
+	// this far from the median brightness of the previous frames
+	BrightnessTolerance Number
+	// A frame changed too much when the mean difference (0 to 255) of its
+	// pixels with the previous good frame is above this
+	DifferenceThreshold Number
+	// Exclude the flagged frames from the videos
+	AutoExclude bool
+}
+
+func (a *FrameAnalysis) WithDefaults() FrameAnalysis {
+	var conf FrameAnalysis = *a
+	if len(conf.Region) != 4 {
+		if len(conf.Region) > 0 {
+			log.Println("FrameAnalysis.Region must be x, y, width, height, using the whole frame")
+		}
+		conf.Region = []float64{0, 0, 1, 1}
+	}
+
+	if conf.BlurThreshold <= 0 {
+		conf.BlurThreshold = 0.5
+	}
+
+	if conf.BrightnessTolerance <= 0 {
+		conf.BrightnessTolerance = 40
+	}
+
+	if conf.DifferenceThreshold <= 0 {
+		conf.DifferenceThreshold = 30
+	}
+
 	return conf
 }
 
 type Config struct {
-	Printer Printer
-	Camera  Camera
-	FFMPEG  FFMPEG
-	Web     Web
+	Printer       Printer
+	Camera        Camera
+	FFMPEG        FFMPEG
+	Web           Web
+	FrameAnalysis FrameAnalysis
 }
 
 func LoadConfig(configPath string) Config {
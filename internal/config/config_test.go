@@ -0,0 +1,59 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestNumbersAsIntegers(t *testing.T) {
+	var conf Config
+	if _, err := toml.Decode(`
+[FrameAnalysis]
+Region = [0, 0, 1, 1]
+BlurThreshold = 0.5
+BrightnessTolerance = 40
+DifferenceThreshold = 30.5
+`, &conf); err != nil {
+		t.Fatal(err)
+	}
+	analysis := conf.FrameAnalysis
+	if !slices.Equal(analysis.Region, []float64{0, 0, 1, 1}) {
+		t.Errorf("Region is %v", analysis.Region)
+	}
+	if analysis.BlurThreshold != 0.5 || analysis.BrightnessTolerance != 40 || analysis.DifferenceThreshold != 30.5 {
+		t.Errorf("thresholds are %v, %v, %v", analysis.BlurThreshold, analysis.BrightnessTolerance, analysis.DifferenceThreshold)
+	}
+
+	if _, err := toml.Decode(`
+[FrameAnalysis]
+BrightnessTolerance = "40"
+`, &conf); err == nil {
+		t.Error("no error for a string")
+	}
+}
+
+func TestCropAsIntegers(t *testing.T) {
+	var conf Config
+	if _, err := toml.Decode(`
+[FFMPEG]
+Crop = [0, 0, 1, 1]
+[FFMPEG.Profiles.short]
+Crop = [0.0, 0.0, 1.0, 0.5]
+`, &conf); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(conf.FFMPEG.Crop, []float64{0, 0, 1, 1}) {
+		t.Errorf("Crop is %v", conf.FFMPEG.Crop)
+	}
+	if crop := conf.FFMPEG.Profiles["short"].Crop; !slices.Equal(crop, []float64{0, 0, 1, 0.5}) {
+		t.Errorf("Crop of the profile is %v", crop)
+	}
+}
+
+func TestSampleConfig(t *testing.T) {
+	if _, err := toml.DecodeFile("../../configs/config.toml", &Config{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,46 +1,245 @@
 package ffmpeg
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/snaps"
 )
 
+// Renders the video of every render profile, one after the other. Each run
+// of ffmpeg gets the whole `TimeoutInMinutes`, whatever the previous ones
+// took.
 func SpawnFFMPEG(capturedPhotosPath string, ffmpegConfig config.FFMPEG) {
 	// ch := make(chan int)
+	timeout := time.Duration(ffmpegConfig.TimeoutInMinutes) * time.Minute
+
+	fileNames := renderedFrames(capturedPhotosPath)
+	if len(fileNames) == 0 {
+		log.Println("No frames to render at", capturedPhotosPath)
+		return
+	}
+	for _, profile := range ffmpegConfig.RenderProfiles() {
+		log.Println("Starting FFMPEG timelapse creation at", capturedPhotosPath, "with profile", profile.Name, "...")
+		if err := render(timeout, capturedPhotosPath, fileNames, profile); err != nil {
+			log.Println("Error: " + err.Error())
+			// ch <- -1
+		} else {
+			log.Println("Timelapse created!")
+			// ch <- 0
+		}
+	}
+}
+
+// ffmpeg runs in the frames' directory, so that the files it is given need
+// no escaping.
+func render(timeout time.Duration, capturedPhotosPath string, fileNames []string, profile config.RenderProfile) error {
+	concatName, err := writeConcatList(capturedPhotosPath, fileNames, profile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filepath.Join(capturedPhotosPath, concatName))
+
+	var filters []string
+	if profile.Stabilization != nil && profile.Stabilization.Enabled {
+		stabilization := profile.Stabilization.WithDefaults()
+		transformsName, err := detectMotion(timeout, capturedPhotosPath, concatName, stabilization)
+		if err != nil {
+			log.Println("Cannot stabilize the video, rendering it as is:", err)
+		} else {
+			defer os.Remove(filepath.Join(capturedPhotosPath, transformsName))
+			filters = append(filters, stabilizationFilters(transformsName, stabilization)...)
+		}
+	}
+	// After the stabilization, which needs the whole frames
+	if crop, err := cropFilter(profile.Crop); err != nil {
+		log.Println("Cannot crop the video, rendering the whole frames:", err)
+	} else if len(crop) > 0 {
+		filters = append(filters, crop)
+	}
+	// After the crop, so that only the brightness of what is kept counts
+	if profile.Deflicker != nil && profile.Deflicker.Enabled {
+		deflicker := profile.Deflicker.WithDefaults()
+		filters = append(filters, fmt.Sprintf("deflicker=size=%d:mode=%s", deflicker.Window, deflicker.Mode))
+	}
+
+	// ffmpeg CMD: `ffmpeg -f concat -safe 0 -i frames.ffconcat -crf 20 -c:v libx264 -pix_fmt yuv420p -s 1920x1280 -r 24 output.mp4`
+	args := []string{"-f", "concat", "-safe", "0", "-i", concatName}
+	// Last, at the size of the video
+	if profile.Overlay != nil && profile.Overlay.Enabled {
+		overlay, err := prepareOverlay(capturedPhotosPath, fileNames, profile)
+		if err != nil {
+			log.Println("Cannot add the overlay, rendering without it:", err)
+		} else {
+			defer overlay.remove(capturedPhotosPath)
+			args = append(args, overlay.inputs()...)
+			args = append(args, "-filter_complex", overlay.filterGraph(filters), "-map", "[out]")
+			filters = nil
+		}
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args,
+		"-crf", "20",
+		"-c:v", profile.Codec,
+		"-pix_fmt", profile.PixelFormat,
+		"-s", profile.OutputVideoResolution, // "1920x1280",
+		"-r", profile.FramesPerSecond,
+		"-y",
+		OutputFileName(profile),
+	)
+	return run(timeout, capturedPhotosPath, args...)
+}
+
+// Runs ffmpeg in the frames' directory, it is killed after `timeout`.
+func run(timeout time.Duration, capturedPhotosPath string, args ...string) error {
 	ctx, cancel := context.WithTimeoutCause(
 		context.Background(),
-		time.Duration(time.Duration(ffmpegConfig.TimeoutInMinutes)*time.Minute),
+		timeout,
 		errors.New("Timed out while creating timelapse"),
 	)
-
 	defer cancel()
 
-	// ffmpeg CMD: `ffmpeg -f image2 -framerate 24 -pattern_type glob -i "*.jpg" -crf 20 -c:v libx264 -pix_fmt yuv420p -s 1920x1280 output.mp4`
-	log.Println("Starting FFMPEG timelapse creation at", capturedPhotosPath, "...")
-	cmd := exec.CommandContext(ctx,
-		"ffmpeg",
-		"-f", "image2",
-		"-framerate", "24",
-		"-pattern_type", "glob",
-		"-i", fmt.Sprintf("%s/snap*.jpg", capturedPhotosPath),
-		"-crf", "20",
-		"-c:v", "libx264",
-		"-pix_fmt", "yuv420p",
-		"-s", ffmpegConfig.OutputVideoResolution, // "1920x1280",
-		"-y",
-		fmt.Sprintf("%s/output.mp4", capturedPhotosPath),
-	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Dir = capturedPhotosPath
 	if err := cmd.Run(); err != nil {
-		log.Println("Error: " + err.Error())
-		// ch <- -1
+		if cause := context.Cause(ctx); cause != nil {
+			return cause
+		}
+		return err
+	}
+	return nil
+}
+
+// First pass of the stabilization, it writes how the camera moves from
+// frame to frame in a file next to the frames, which the caller removes.
+func detectMotion(timeout time.Duration, capturedPhotosPath string, concatName string, stabilization config.Stabilization) (string, error) {
+	f, err := os.CreateTemp(capturedPhotosPath, ".render-*.trf")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	transformsName := filepath.Base(f.Name())
+
+	detect := fmt.Sprintf("vidstabdetect=shakiness=%d:accuracy=15:result=%s", stabilization.Shakiness, transformsName)
+	if stabilization.Smoothing == 0 {
+		detect += ":tripod=1"
+	}
+	if err := run(timeout, capturedPhotosPath, "-f", "concat", "-safe", "0", "-i", concatName, "-vf", detect, "-f", "null", "-"); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return transformsName, nil
+}
+
+// `crop` is x, y, width, height as fractions of the frames, empty for none.
+func cropFilter(crop []float64) (string, error) {
+	if len(crop) == 0 {
+		return "", nil
+	}
+	if len(crop) != 4 {
+		return "", fmt.Errorf("Crop must be x, y, width, height, got %v", crop)
+	}
+	x, y, width, height := crop[0], crop[1], crop[2], crop[3]
+	if x < 0 || y < 0 || width <= 0 || height <= 0 || x+width > 1 || y+height > 1 {
+		return "", fmt.Errorf("Crop must be within the frames, got %v", crop)
+	}
+	return fmt.Sprintf("crop=w=iw*%g:h=ih*%g:x=iw*%g:y=ih*%g", width, height, x, y), nil
+}
+
+// Second pass of the stabilization. `optzoom=1` zooms in just enough for
+// the borders to never be visible, so they do not flicker.
+func stabilizationFilters(transformsName string, stabilization config.Stabilization) []string {
+	transform := fmt.Sprintf("vidstabtransform=input=%s:optzoom=1:crop=black", transformsName)
+	if stabilization.Smoothing == 0 {
+		transform += ":tripod=1"
 	} else {
-		log.Println("Timelapse created!")
-		// ch <- 0
+		transform += fmt.Sprintf(":smoothing=%d", stabilization.Smoothing)
+	}
+	// The interpolation softens the frames a bit
+	return []string{transform, "unsharp=5:5:0.8:3:3:0.4"}
+}
+
+// The frames which go in the video, in order: the excluded ones are left
+// out, and folders captured before frames were numbered only have legacy
+// names, a folder with both is rendered from the numbered ones only.
+func renderedFrames(capturedPhotosPath string) []string {
+	fileNames, _ := snaps.List(capturedPhotosPath)
+	excluded, err := snaps.Excluded(capturedPhotosPath)
+	if err != nil {
+		log.Println("Cannot read the excluded frames, rendering all of them", err)
+	}
+	var legacy, numbered []string
+	for _, fileName := range fileNames {
+		if excluded[fileName] {
+			continue
+		}
+		if name, _ := snaps.Parse(fileName); name.Legacy() {
+			legacy = append(legacy, fileName)
+		} else {
+			numbered = append(numbered, fileName)
+		}
+	}
+	if len(excluded) > 0 {
+		log.Printf("%d frames of %s are excluded\n", len(excluded), capturedPhotosPath)
+	}
+	if len(numbered) == 0 {
+		return legacy
+	}
+	if len(legacy) > 0 {
+		log.Printf("%s has %d frames with legacy names which are not rendered, see the migrate-frames command\n", capturedPhotosPath, len(legacy))
+	}
+	return numbered
+}
+
+// Writes the ffmpeg concat list of the frames next to them, each one lasting
+// a frame of the video, and returns its name. The caller removes it.
+func writeConcatList(capturedPhotosPath string, fileNames []string, profile config.RenderProfile) (string, error) {
+	fps, err := profile.FrameRate()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(capturedPhotosPath, ".render-*.ffconcat")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "ffconcat version 1.0")
+	for _, fileName := range fileNames {
+		fmt.Fprintf(w, "file %s\nduration %f\n", quote(fileName), 1/fps)
+	}
+	// The duration of the last file is only taken into account when it is
+	// followed by another one
+	fmt.Fprintf(w, "file %s\n", quote(fileNames[len(fileNames)-1]))
+	if err := w.Flush(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return filepath.Base(f.Name()), nil
+}
+
+// Single quotes, for the concat list (where paths are relative to the list)
+// and the sendcmd commands.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// The main video keeps its historical name, the web UI plays it.
+func OutputFileName(profile config.RenderProfile) string {
+	if profile.Name == config.DEFAULT_RENDER_PROFILE {
+		return "output.mp4"
 	}
+	return fmt.Sprintf("output-%s.mp4", profile.Name)
 }
@@ -0,0 +1,56 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+)
+
+// Puts an `ffmpeg` on the PATH which takes `duration` and records each run
+// which was not killed.
+func fakeFFMPEG(t *testing.T, duration time.Duration) string {
+	t.Helper()
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	script := fmt.Sprintf("#!/bin/sh\nsleep %.2f\n", duration.Seconds()) + "echo done >> " + runs + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return runs
+}
+
+func TestEachRunHasItsOwnTimeout(t *testing.T) {
+	runs := fakeFFMPEG(t, 300*time.Millisecond)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "frame-000001.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	profile := config.RenderProfile{
+		Name:                  config.DEFAULT_RENDER_PROFILE,
+		OutputVideoResolution: "1920x1080",
+		FramesPerSecond:       "24",
+		Stabilization:         &config.Stabilization{Enabled: true},
+	}
+
+	// Both passes of the stabilization take longer than the timeout together
+	if err := render(500*time.Millisecond, dir, []string{"frame-000001.jpg"}, profile); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "done"); n != 2 {
+		t.Errorf("%d runs of ffmpeg finished, want 2", n)
+	}
+
+	if err := render(100*time.Millisecond, dir, []string{"frame-000001.jpg"}, config.RenderProfile{FramesPerSecond: "24"}); err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Errorf("render returned %v, want a timeout", err)
+	}
+}
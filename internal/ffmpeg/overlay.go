@@ -0,0 +1,213 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/manifest"
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+// The text and progress bar of every frame are changed through a `sendcmd`
+// file, the rest of the overlay is set up in the filter graph.
+type overlay struct {
+	conf          config.Overlay
+	width, height int
+	// Absolute, as ffmpeg runs in the frames' directory
+	watermark    string
+	commandsName string
+	firstText    string
+}
+
+func prepareOverlay(capturedPhotosPath string, fileNames []string, profile config.RenderProfile) (*overlay, error) {
+	o := &overlay{conf: profile.Overlay.WithDefaults()}
+	if _, err := fmt.Sscanf(profile.OutputVideoResolution, "%dx%d", &o.width, &o.height); err != nil {
+		return nil, fmt.Errorf("cannot read OutputVideoResolution %q: %w", profile.OutputVideoResolution, err)
+	}
+	if len(o.conf.Watermark) > 0 {
+		watermark, err := filepath.Abs(o.conf.Watermark)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(watermark); err != nil {
+			return nil, err
+		}
+		o.watermark = watermark
+	}
+	fps, err := profile.FrameRate()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := readMetadata(capturedPhotosPath)
+	if err != nil {
+		return nil, err
+	}
+	// The folder is named after the print start, the first frame is used
+	// for the other ones
+	printStart, _ := utils.PhotoDirectoryTime(capturedPhotosPath)
+	for _, m := range metadata {
+		if printStart.IsZero() || m.CapturedAt.Before(printStart) {
+			printStart = m.CapturedAt
+		}
+	}
+
+	f, err := os.CreateTemp(capturedPhotosPath, ".render-*.cmd")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	o.commandsName = filepath.Base(f.Name())
+
+	w := bufio.NewWriter(f)
+	for i, fileName := range fileNames {
+		m, found := metadata[fileName]
+		text := ""
+		if found {
+			text = o.text(m, printStart)
+		}
+		if i == 0 {
+			o.firstText = text
+		}
+		progress := float64(i+1) / float64(len(fileNames))
+		if found && m.Job != nil {
+			progress = float64(m.Job.Progress) / 100
+		}
+		// Half a frame early, so that it is not missed because of rounding
+		fmt.Fprintf(w, "%f drawtext@info reinit %s", max(0, (float64(i)-0.5)/fps), quote("text="+escapeOption(text)))
+		if o.conf.ProgressBar {
+			fmt.Fprintf(w, ", overlay@progress x %d", int(float64(o.width)*min(1, progress))-o.width)
+		}
+		fmt.Fprintln(w, ";")
+	}
+	if err := w.Flush(); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return o, nil
+}
+
+// The caller removes the commands file.
+func (o *overlay) remove(capturedPhotosPath string) {
+	os.Remove(filepath.Join(capturedPhotosPath, o.commandsName))
+}
+
+func (o *overlay) inputs() []string {
+	if len(o.watermark) == 0 {
+		return nil
+	}
+	return []string{"-i", o.watermark}
+}
+
+// The whole filter graph, `filters` are applied first. Its output is
+// labelled `out`.
+func (o *overlay) filterGraph(filters []string) string {
+	margin := o.height / 40
+	barHeight := max(4, o.height/100)
+
+	drawtext := []string{
+		"expansion=none",
+		"fontcolor=white",
+		fmt.Sprintf("fontsize=%d", o.height/30),
+		"box=1",
+		"boxcolor=black@0.5",
+		fmt.Sprintf("boxborderw=%d", o.height/120),
+		fmt.Sprintf("x=%d", margin),
+		fmt.Sprintf("y=h-text_h-%d", margin+barHeight),
+		"text=" + escapeFilterValue(o.firstText),
+	}
+	if len(o.conf.FontFile) > 0 {
+		drawtext = append(drawtext, "fontfile="+escapeFilterValue(o.conf.FontFile))
+	}
+
+	chain := append(slices.Clone(filters),
+		fmt.Sprintf("scale=%d:%d", o.width, o.height),
+		"sendcmd=f="+o.commandsName,
+		"drawtext@info="+strings.Join(drawtext, ":"),
+	)
+	graph := []string{"[0:v]" + strings.Join(chain, ",") + "[text]"}
+	last := "text"
+	if o.conf.ProgressBar {
+		graph = append(graph,
+			fmt.Sprintf("color=c=white@0.8:s=%dx%d,format=rgba[bar]", o.width, barHeight),
+			fmt.Sprintf("[%s][bar]overlay@progress=x=-w:y=H-h:shortest=1[progress]", last),
+		)
+		last = "progress"
+	}
+	if len(o.watermark) > 0 {
+		graph = append(graph,
+			fmt.Sprintf("[1:v]scale=-1:%d[watermark]", o.height/10),
+			fmt.Sprintf("[%s][watermark]overlay=x=W-w-%d:y=%d[watermarked]", last, margin, margin),
+		)
+		last = "watermarked"
+	}
+	graph = append(graph, fmt.Sprintf("[%s]null[out]", last))
+	return strings.Join(graph, ";")
+}
+
+func (o *overlay) text(m manifest.Metadata, printStart time.Time) string {
+	var parts []string
+	for _, field := range o.conf.Fields {
+		switch field {
+		case "job":
+			if m.Job != nil && len(m.Job.Name) > 0 {
+				parts = append(parts, strings.TrimSuffix(strings.TrimSuffix(m.Job.Name, ".gcode"), ".bgcode"))
+			}
+		case "layer":
+			if m.Layer != nil {
+				parts = append(parts, fmt.Sprintf("Layer %d", *m.Layer))
+			}
+		case "z":
+			if m.Z != nil {
+				parts = append(parts, fmt.Sprintf("Z %.2f mm", *m.Z))
+			}
+		case "elapsed":
+			elapsed := m.CapturedAt.Sub(printStart).Round(time.Second)
+			parts = append(parts, fmt.Sprintf("%d:%02d:%02d", int(elapsed.Hours()), int(elapsed.Minutes())%60, int(elapsed.Seconds())%60))
+		case "clock":
+			parts = append(parts, m.CapturedAt.Local().Format("15:04"))
+		}
+	}
+	return strings.Join(parts, "  |  ")
+}
+
+// The metadata of the frames, by file name, empty for folders without a
+// manifest.
+func readMetadata(capturedPhotosPath string) (map[string]manifest.Metadata, error) {
+	frames, err := manifest.Read(capturedPhotosPath)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]manifest.Metadata, len(frames))
+	for _, m := range frames {
+		metadata[m.FileName] = m
+	}
+	return metadata, nil
+}
+
+// Escaping for an option value of a filter given to `reinit`.
+func escapeOption(s string) string {
+	return escape(s, `\':`)
+}
+
+// Escaping for an option value in the filter graph, which is escaped again.
+func escapeFilterValue(s string) string {
+	return escape(escapeOption(s), `\'[],;`)
+}
+
+func escape(s string, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
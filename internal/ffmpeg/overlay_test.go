@@ -0,0 +1,50 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/manifest"
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+func TestOverlayElapsed(t *testing.T) {
+	printStart := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	profile := config.RenderProfile{
+		OutputVideoResolution: "1920x1080",
+		FramesPerSecond:       "24",
+		Overlay:               &config.Overlay{Enabled: true, Fields: []string{"elapsed"}},
+	}
+
+	for _, c := range []struct {
+		folderName string
+		want       string
+	}{
+		{printStart.Format(utils.PHOTO_DIRECTORY_LAYOUT), "0:05:00"},
+		// Not named after the print start
+		{"orphans", "0:00:00"},
+	} {
+		dir := filepath.Join(t.TempDir(), c.folderName)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i, fileName := range []string{"frame-000001.jpg", "frame-000002.jpg"} {
+			m := manifest.Metadata{FileName: fileName, CapturedAt: printStart.Add(5*time.Minute + time.Duration(i)*time.Minute)}
+			if err := manifest.Append(dir, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		o, err := prepareOverlay(dir, []string{"frame-000001.jpg", "frame-000002.jpg"}, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.remove(dir)
+		if o.firstText != c.want {
+			t.Errorf("elapsed is %q in %s, want %q", o.firstText, c.folderName, c.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package frames
+
+import (
+	"bufio"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/manifest"
+	"golang.org/x/image/draw"
+)
+
+// Frames are scaled down to this width before they are analyzed.
+const ANALYSIS_WIDTH = 640
+
+// How many of the previous good frames the sharpness and brightness of a
+// frame are compared to, and how many are needed to compare them at all.
+const (
+	analysisHistory    = 10
+	analysisMinHistory = 3
+)
+
+// After this many flagged frames in a row the scene is considered to have
+// changed for good (the camera was moved, the lights switched on), the next
+// frames are compared to the last one.
+const analysisMaxFlaggedInARow = 3
+
+// Why a frame looks wrong.
+const (
+	FLAG_BLURRY     = "blurry"
+	FLAG_TOO_DARK   = "too dark"
+	FLAG_TOO_BRIGHT = "too bright"
+	FLAG_DIFFERENT  = "different"
+)
+
+// Analyzer scores each frame against the previous good frames of its print
+// folder. It keeps state between frames, so it must only be used from one
+// goroutine.
+type Analyzer struct {
+	conf config.FrameAnalysis
+	// Of the print folder of the last frame, only one print is captured at a
+	// time
+	session *analyzerSession
+}
+
+type analyzerSession struct {
+	snapshotsDir string
+	// The last good frames, oldest first
+	history []manifest.Quality
+	// The last good frame, nil until a frame was analyzed
+	reference     *image.Gray
+	flaggedInARow int
+}
+
+func NewAnalyzer(conf config.FrameAnalysis) *Analyzer {
+	return &Analyzer{conf: conf.WithDefaults()}
+}
+
+func (a *Analyzer) AutoExclude() bool {
+	return a.conf.AutoExclude
+}
+
+func (a *Analyzer) Analyze(snapPath string) (manifest.Quality, error) {
+	gray, err := a.loadRegion(snapPath)
+	if err != nil {
+		return manifest.Quality{}, err
+	}
+	s := a.sessionOf(filepath.Dir(snapPath))
+
+	q := manifest.Quality{Sharpness: laplacianVariance(gray), Brightness: meanLuma(gray)}
+	if s.reference != nil && s.reference.Bounds() == gray.Bounds() {
+		difference := meanDifference(gray, s.reference)
+		q.Difference = &difference
+	}
+
+	if len(s.history) >= analysisMinHistory {
+		sharpness := median(s.history, func(q manifest.Quality) float64 { return q.Sharpness })
+		if q.Sharpness < sharpness*float64(a.conf.BlurThreshold) {
+			q.Flags = append(q.Flags, FLAG_BLURRY)
+		}
+		brightness := median(s.history, func(q manifest.Quality) float64 { return q.Brightness })
+		if q.Brightness < brightness-float64(a.conf.BrightnessTolerance) {
+			q.Flags = append(q.Flags, FLAG_TOO_DARK)
+		} else if q.Brightness > brightness+float64(a.conf.BrightnessTolerance) {
+			q.Flags = append(q.Flags, FLAG_TOO_BRIGHT)
+		}
+	}
+	if q.Difference != nil && *q.Difference > float64(a.conf.DifferenceThreshold) {
+		q.Flags = append(q.Flags, FLAG_DIFFERENT)
+	}
+
+	// The next frames are compared to good frames only, so that a single
+	// bad one does not get the following ones flagged too
+	if len(q.Flags) == 0 {
+		s.history = append(s.history, q)
+		if len(s.history) > analysisHistory {
+			s.history = s.history[1:]
+		}
+		s.reference = gray
+		s.flaggedInARow = 0
+	} else if s.flaggedInARow++; s.flaggedInARow >= analysisMaxFlaggedInARow {
+		s.history = []manifest.Quality{q}
+		s.reference = gray
+		s.flaggedInARow = 0
+	}
+	return q, nil
+}
+
+// The state of a print folder, the one of the previous print is dropped.
+// The history is read back from the manifest when the daemon was restarted
+// during the print.
+func (a *Analyzer) sessionOf(snapshotsDir string) *analyzerSession {
+	if a.session != nil && a.session.snapshotsDir == snapshotsDir {
+		return a.session
+	}
+	s := &analyzerSession{snapshotsDir: snapshotsDir}
+	frames, _ := manifest.Read(snapshotsDir)
+	for _, m := range frames {
+		if m.Quality != nil && len(m.Quality.Flags) == 0 {
+			s.history = append(s.history, *m.Quality)
+		}
+	}
+	if len(s.history) > analysisHistory {
+		s.history = s.history[len(s.history)-analysisHistory:]
+	}
+	a.session = s
+	return s
+}
+
+// The configured region of a frame, scaled down and in grayscale.
+func (a *Analyzer) loadRegion(snapPath string) (*image.Gray, error) {
+	f, err := os.Open(snapPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src, err := jpeg.Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	region := a.conf.Region
+	crop := image.Rect(
+		bounds.Min.X+int(region[0]*float64(bounds.Dx())),
+		bounds.Min.Y+int(region[1]*float64(bounds.Dy())),
+		bounds.Min.X+int((region[0]+region[2])*float64(bounds.Dx())),
+		bounds.Min.Y+int((region[1]+region[3])*float64(bounds.Dy())),
+	).Intersect(bounds)
+	if crop.Empty() {
+		crop = bounds
+	}
+
+	width := min(ANALYSIS_WIDTH, crop.Dx())
+	height := max(1, crop.Dy()*width/crop.Dx())
+	gray := image.NewGray(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(gray, gray.Bounds(), src, crop, draw.Src, nil)
+	return gray, nil
+}
+
+// Blurry pictures have few edges, so a low variance of their Laplacian.
+func laplacianVariance(gray *image.Gray) float64 {
+	bounds := gray.Bounds()
+	var sum, sumOfSquares float64
+	n := 0
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
+		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
+			l := 4*float64(gray.GrayAt(x, y).Y) -
+				float64(gray.GrayAt(x-1, y).Y) -
+				float64(gray.GrayAt(x+1, y).Y) -
+				float64(gray.GrayAt(x, y-1).Y) -
+				float64(gray.GrayAt(x, y+1).Y)
+			sum += l
+			sumOfSquares += l * l
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / float64(n)
+	return sumOfSquares/float64(n) - mean*mean
+}
+
+func meanLuma(gray *image.Gray) float64 {
+	if len(gray.Pix) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, p := range gray.Pix {
+		sum += float64(p)
+	}
+	return sum / float64(len(gray.Pix))
+}
+
+// Both images have the same bounds.
+func meanDifference(a *image.Gray, b *image.Gray) float64 {
+	if len(a.Pix) == 0 {
+		return 0
+	}
+	var sum float64
+	for i := range a.Pix {
+		sum += math.Abs(float64(a.Pix[i]) - float64(b.Pix[i]))
+	}
+	return sum / float64(len(a.Pix))
+}
+
+func median(history []manifest.Quality, value func(manifest.Quality) float64) float64 {
+	values := make([]float64, len(history))
+	for i, q := range history {
+		values[i] = value(q)
+	}
+	slices.Sort(values)
+	if len(values)%2 == 1 {
+		return values[len(values)/2]
+	}
+	return (values[len(values)/2-1] + values[len(values)/2]) / 2
+}
@@ -0,0 +1,59 @@
+package frames
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+)
+
+// Writes a frame of vertical stripes, `luma` bright.
+func writeFrame(t *testing.T, path string, luma uint8) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			if x%8 < 4 {
+				img.SetGray(x, y, color.Gray{Y: luma})
+			}
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalyzerKeepsTheCurrentPrintOnly(t *testing.T) {
+	a := NewAnalyzer(config.FrameAnalysis{Enabled: true})
+	first, second := t.TempDir(), t.TempDir()
+	for i, dir := range []string{first, first, first, first, second} {
+		path := filepath.Join(dir, "frame.jpg")
+		writeFrame(t, path, 200)
+		q, err := a.Analyze(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(q.Flags) != 0 {
+			t.Errorf("frame %d flagged %v", i, q.Flags)
+		}
+	}
+	if a.session.snapshotsDir != second || len(a.session.history) != 1 {
+		t.Errorf("session of %s with %d frames, want the second print's with 1", a.session.snapshotsDir, len(a.session.history))
+	}
+
+	// Compared to the frames of the second print only
+	path := filepath.Join(second, "frame.jpg")
+	writeFrame(t, path, 20)
+	if q, _ := a.Analyze(path); len(q.Flags) != 1 || q.Flags[0] != FLAG_DIFFERENT {
+		t.Errorf("flags are %v, want %v", q.Flags, []string{FLAG_DIFFERENT})
+	}
+}
@@ -0,0 +1,134 @@
+package frames
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pyrho/timelapse-serial/internal/capture"
+	"github.com/pyrho/timelapse-serial/internal/manifest"
+	"github.com/pyrho/timelapse-serial/internal/snaps"
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+// Records the metadata of every captured frame in the manifest of its
+// snapshots directory, and in the picture itself (XMP) when `embedXMP` is
+// set. `temperatures` and `job` are optional, they return nil when unknown.
+// `analyzer` is optional too, when set the quality of the frame is recorded
+// and flagged frames may be excluded from the videos.
+// Like every post-processor this only ever runs on the worker's
+// post-processing goroutine.
+func CreateMetadataPostProcessor(temperatures func() *manifest.Temperatures, job func() *manifest.Job, embedXMP bool, analyzer *Analyzer) capture.PostProcessor {
+	// Last frame index of each snapshots directory
+	lastIndexes := make(map[string]int)
+
+	return func(r capture.Result) {
+		if r.Err != nil {
+			return
+		}
+		snapshotsDir := filepath.Dir(r.SnapPath)
+		lastIndex, found := lastIndexes[snapshotsDir]
+		if !found {
+			existing, _ := manifest.Read(snapshotsDir)
+			lastIndex = len(existing)
+		}
+
+		m := manifest.Metadata{
+			SessionID:    filepath.Base(snapshotsDir),
+			Index:        lastIndex + 1,
+			FileName:     filepath.Base(r.SnapPath),
+			RequestedAt:  r.ReceivedAt,
+			CapturedAt:   r.StartedAt,
+			QueueDelayMs: r.QueueDelay().Milliseconds(),
+			LatencyMs:    r.Duration.Milliseconds(),
+		}
+		if name, ok := snaps.Parse(m.FileName); ok && !name.Legacy() {
+			m.Index = name.Sequence
+		}
+		if layer, err := strconv.Atoi(r.Params["layer"]); err == nil {
+			m.Layer = &layer
+		}
+		if z, err := strconv.ParseFloat(r.Params["z"], 64); err == nil {
+			m.Z = &z
+		}
+		if temperatures != nil {
+			m.Temperatures = temperatures()
+		}
+		if job != nil {
+			m.Job = job()
+		}
+		if analyzer != nil {
+			analyze(analyzer, r.SnapPath, &m)
+		}
+
+		if err := manifest.Append(snapshotsDir, m); err != nil {
+			log.Println("Cannot record frame metadata", err)
+			return
+		}
+		lastIndexes[snapshotsDir] = m.Index
+
+		if embedXMP {
+			if err := writeXMP(r.SnapPath, m); err != nil {
+				log.Println("Cannot write frame metadata in", r.SnapPath, err)
+			}
+		}
+	}
+}
+
+func analyze(analyzer *Analyzer, snapPath string, m *manifest.Metadata) {
+	quality, err := analyzer.Analyze(snapPath)
+	if err != nil {
+		log.Println("Cannot analyze", snapPath, err)
+		return
+	}
+	m.Quality = &quality
+	if len(quality.Flags) == 0 {
+		return
+	}
+	log.Printf("Frame %s looks wrong: %s\n", m.FileName, strings.Join(quality.Flags, ", "))
+	if analyzer.AutoExclude() {
+		if err := snaps.SetExcluded(filepath.Dir(snapPath), m.FileName, true); err != nil {
+			log.Println("Cannot exclude", snapPath, err)
+		}
+	}
+}
+
+// The metadata goes in the picture's XMP, or in a sidecar `.xmp` file next to
+// it when the XMP written by the camera cannot take it.
+func writeXMP(snapPath string, m manifest.Metadata) error {
+	comment, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	jpeg, err := os.ReadFile(snapPath)
+	if err != nil {
+		return err
+	}
+	tagged, merged, err := embedXMPComment(jpeg, string(comment))
+	if errors.Is(err, errCannotMergeXMP) {
+		sidecarPath := filepath.Join(filepath.Dir(snapPath), snaps.XMPSidecarName(filepath.Base(snapPath)))
+		packet, err := xmpCommentPacket(string(comment))
+		if err != nil {
+			return err
+		}
+		if err := utils.WriteFileAtomically(sidecarPath, []byte(packet)); err != nil {
+			return err
+		}
+		log.Println("The XMP of", snapPath, "cannot take the frame metadata, it is in", sidecarPath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomically(snapPath, tagged); err != nil {
+		return err
+	}
+	if merged {
+		log.Println("Frame metadata merged into the XMP written by the camera in", snapPath)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package frames
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+// APP1 segments starting with this hold XMP, see the XMP specification part 3.
+const xmpNamespace = "http://ns.adobe.com/xap/1.0/\x00"
+
+// The largest XMP packet a single APP1 segment can hold.
+const xmpMaxPacketSize = 0xFFFF - 2 - len(xmpNamespace)
+
+var errNotJpeg = errors.New("not a JPEG file")
+
+// The XMP written by the camera cannot take the comment, e.g. it already
+// has one, the comment goes in a sidecar file instead.
+var errCannotMergeXMP = errors.New("cannot add to the XMP metadata of the JPEG")
+
+const xmpCommentFormat = `<rdf:Description rdf:about="" xmlns:exif="http://ns.adobe.com/exif/1.0/">` +
+	`<exif:UserComment><rdf:Alt><rdf:li xml:lang="x-default">%s</rdf:li></rdf:Alt></exif:UserComment>` +
+	`</rdf:Description>`
+
+// A whole XMP packet with the comment as `exif:UserComment`.
+func xmpCommentPacket(comment string) (string, error) {
+	description, err := xmpCommentDescription(comment)
+	if err != nil {
+		return "", err
+	}
+	return `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/">` +
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		description +
+		`</rdf:RDF></x:xmpmeta><?xpacket end="w"?>`, nil
+}
+
+func xmpCommentDescription(comment string) (string, error) {
+	var escaped bytes.Buffer
+	if err := xml.EscapeText(&escaped, []byte(comment)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(xmpCommentFormat, escaped.String()), nil
+}
+
+// Adds the comment to the JPEG's XMP as `exif:UserComment`. Most cameras
+// write XMP already, the comment is then added to their packet as another
+// `rdf:Description`, which is reported by `merged`. Otherwise a new XMP
+// segment is added after the segments written by the camera (JFIF, EXIF).
+// Fails with errCannotMergeXMP when the camera's packet cannot take it.
+func embedXMPComment(jpeg []byte, comment string) (tagged []byte, merged bool, err error) {
+	if len(jpeg) < 4 || jpeg[0] != 0xFF || jpeg[1] != 0xD8 {
+		return nil, false, errNotJpeg
+	}
+
+	// Skip the APPn segments, looking for the XMP one
+	pos := 2
+	for pos+4 <= len(jpeg) && jpeg[pos] == 0xFF && jpeg[pos+1] >= 0xE0 && jpeg[pos+1] <= 0xEF {
+		length := int(binary.BigEndian.Uint16(jpeg[pos+2:]))
+		if pos+2+length > len(jpeg) {
+			return nil, false, errNotJpeg
+		}
+		segment := jpeg[pos+4 : pos+2+length]
+		if jpeg[pos+1] == 0xE1 && bytes.HasPrefix(segment, []byte(xmpNamespace)) {
+			packet, err := mergeXMPComment(segment[len(xmpNamespace):], comment)
+			if err != nil {
+				return nil, false, err
+			}
+			return replaceSegment(jpeg, pos, 2+length, xmpSegment(packet)), true, nil
+		}
+		pos += 2 + length
+	}
+
+	packet, err := xmpCommentPacket(comment)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(packet) > xmpMaxPacketSize {
+		return nil, false, errors.New("XMP metadata is too large")
+	}
+	return replaceSegment(jpeg, pos, 0, xmpSegment([]byte(packet))), false, nil
+}
+
+// Adds the comment's description to the packet's `rdf:RDF`.
+func mergeXMPComment(packet []byte, comment string) ([]byte, error) {
+	end := bytes.LastIndex(packet, []byte("</rdf:RDF>"))
+	if end < 0 || bytes.Contains(packet, []byte(":UserComment")) {
+		return nil, errCannotMergeXMP
+	}
+	description, err := xmpCommentDescription(comment)
+	if err != nil {
+		return nil, err
+	}
+	merged := make([]byte, 0, len(packet)+len(description))
+	merged = append(merged, packet[:end]...)
+	merged = append(merged, description...)
+	merged = append(merged, packet[end:]...)
+	if len(merged) > xmpMaxPacketSize {
+		return nil, errCannotMergeXMP
+	}
+	return merged, nil
+}
+
+// The APP1 segment of an XMP packet, marker included.
+func xmpSegment(packet []byte) []byte {
+	payload := append([]byte(xmpNamespace), packet...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// Replaces the `length` bytes at `pos` with the segment.
+func replaceSegment(jpeg []byte, pos int, length int, segment []byte) []byte {
+	out := make([]byte, 0, len(jpeg)-length+len(segment))
+	out = append(out, jpeg[:pos]...)
+	out = append(out, segment...)
+	return append(out, jpeg[pos+length:]...)
+}
@@ -0,0 +1,116 @@
+package frames
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"testing"
+)
+
+// A JPEG without image data: SOI, APPn segments, EOI.
+func testJpeg(segments ...[]byte) []byte {
+	jpeg := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		jpeg = append(jpeg, s...)
+	}
+	return append(jpeg, 0xFF, 0xD9)
+}
+
+var jfifSegment = []byte{0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00}
+
+// The packets of every XMP segment of the JPEG.
+func xmpPackets(t *testing.T, jpeg []byte) []string {
+	t.Helper()
+	var packets []string
+	pos := 2
+	for jpeg[pos+1] != 0xD9 {
+		length := int(jpeg[pos+2])<<8 | int(jpeg[pos+3])
+		segment := jpeg[pos+4 : pos+2+length]
+		if payload, found := bytes.CutPrefix(segment, []byte(xmpNamespace)); found {
+			packets = append(packets, string(payload))
+		}
+		pos += 2 + length
+	}
+	return packets
+}
+
+// The text of the UserComment of every rdf:Description, the packet must be
+// well-formed.
+func userComments(t *testing.T, packet string) []string {
+	t.Helper()
+	var comments []string
+	decoder := xml.NewDecoder(bytes.NewReader([]byte(packet)))
+	inComment := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			inComment = inComment || token.Name.Local == "UserComment"
+			if inComment && token.Name.Local == "li" {
+				var text string
+				if err := decoder.DecodeElement(&text, &token); err != nil {
+					t.Fatal(err)
+				}
+				comments = append(comments, text)
+			}
+		case xml.EndElement:
+			if token.Name.Local == "UserComment" {
+				inComment = false
+			}
+		}
+	}
+	return comments
+}
+
+func TestEmbedXMPComment(t *testing.T) {
+	tagged, merged, err := embedXMPComment(testJpeg(jfifSegment), `{"Index":1,"Job":"<benchy>"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged {
+		t.Error("reported as merged without XMP from the camera")
+	}
+	packets := xmpPackets(t, tagged)
+	if len(packets) != 1 {
+		t.Fatalf("%d XMP segments, want 1", len(packets))
+	}
+	if comments := userComments(t, packets[0]); len(comments) != 1 || comments[0] != `{"Index":1,"Job":"<benchy>"}` {
+		t.Errorf("comments are %q", comments)
+	}
+	if !bytes.HasPrefix(tagged, append([]byte{0xFF, 0xD8}, jfifSegment...)) {
+		t.Error("the XMP segment is not after the camera's segments")
+	}
+}
+
+func TestMergeXMPComment(t *testing.T) {
+	cameraPacket := `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>` +
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="0"/>` +
+		`</rdf:RDF></x:xmpmeta>` + "\n    \n" + `<?xpacket end="w"?>`
+
+	tagged, merged, err := embedXMPComment(testJpeg(jfifSegment, xmpSegment([]byte(cameraPacket))), `{"Index":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merged {
+		t.Error("not reported as merged")
+	}
+	packets := xmpPackets(t, tagged)
+	if len(packets) != 1 {
+		t.Fatalf("%d XMP segments, want 1", len(packets))
+	}
+	if comments := userComments(t, packets[0]); len(comments) != 1 || comments[0] != `{"Index":2}` {
+		t.Errorf("comments are %q", comments)
+	}
+	if !bytes.Contains([]byte(packets[0]), []byte(`xmp:Rating="0"`)) {
+		t.Error("the camera's metadata is gone")
+	}
+
+	// Merging again would give two comments
+	if _, _, err := embedXMPComment(tagged, `{"Index":3}`); !errors.Is(err, errCannotMergeXMP) {
+		t.Errorf("merging twice returned %v, want %v", err, errCannotMergeXMP)
+	}
+}
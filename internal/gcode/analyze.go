@@ -0,0 +1,108 @@
+package gcode
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What a sliced file tells us about the timelapse it will produce.
+type Analysis struct {
+	Binary bool
+	Layers int
+	// `action:capture` commands already in the file
+	CaptureMarkers int
+	// Zero when the slicer did not write an estimate
+	EstimatedPrintTime time.Duration
+}
+
+// Frames the print will produce: one per capture marker, or one per layer
+// when the file was not prepared yet (which is what `Inject` adds).
+func (a Analysis) Frames() int {
+	if a.CaptureMarkers > 0 {
+		return a.CaptureMarkers
+	}
+	return a.Layers
+}
+
+// Reads a plain or binary G-code file.
+func Analyze(r io.Reader) (Analysis, error) {
+	var a Analysis
+	reader := bufio.NewReaderSize(r, 64*1024)
+	if isBinaryGcode(reader) {
+		a.Binary = true
+		err := readBinaryGcode(reader, a.line, a.metadata)
+		return a, err
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		a.line(strings.TrimRight(scanner.Text(), "\r"))
+	}
+	return a, scanner.Err()
+}
+
+func (a *Analysis) line(line string) {
+	if isLayerChange(line) {
+		a.Layers++
+		return
+	}
+	code, comment, _ := strings.Cut(line, ";")
+	if strings.Contains(code, "action:capture") {
+		a.CaptureMarkers++
+	}
+	if len(comment) > 0 {
+		a.metadata(comment)
+	}
+}
+
+// Print time estimates, from the comments of a plain file or the metadata
+// of a binary one.
+func (a *Analysis) metadata(line string) {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "estimated printing time (normal mode)"):
+		// PrusaSlicer, `estimated printing time (normal mode) = 1h 2m 3s`
+		_, value, _ := strings.Cut(line, "=")
+		if d, ok := parseSlicerDuration(value); ok {
+			a.EstimatedPrintTime = d
+		}
+	case strings.HasPrefix(line, "TIME:"):
+		// Cura, in seconds
+		if s, err := strconv.Atoi(strings.TrimPrefix(line, "TIME:")); err == nil {
+			a.EstimatedPrintTime = time.Duration(s) * time.Second
+		}
+	case strings.Contains(line, "total estimated time:"):
+		// OrcaSlicer, `model printing time: 1h 2m; total estimated time: 1h 5m`
+		_, value, _ := strings.Cut(line, "total estimated time:")
+		value, _, _ = strings.Cut(value, ";")
+		if d, ok := parseSlicerDuration(value); ok {
+			a.EstimatedPrintTime = d
+		}
+	}
+}
+
+// Parses durations like `1d 2h 3m 4s`.
+func parseSlicerDuration(s string) (time.Duration, bool) {
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'h': time.Hour, 'm': time.Minute, 's': time.Second}
+	var total time.Duration
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	for _, f := range fields {
+		unit, found := units[f[len(f)-1]]
+		if !found {
+			return 0, false
+		}
+		n, err := strconv.Atoi(f[:len(f)-1])
+		if err != nil {
+			return 0, false
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, true
+}
@@ -0,0 +1,149 @@
+package gcode
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+)
+
+func TestAnalyze(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		gcode string
+		want  Analysis
+	}{
+		{
+			name: "prusaslicer",
+			gcode: "; generated by PrusaSlicer 2.7.1 on 2024-05-01 at 10:00:00 UTC\r\n" +
+				";LAYER_CHANGE\r\n;Z:0.2\r\nG1 X10 Y10 E1\r\n" +
+				";LAYER_CHANGE\r\n;Z:0.4\r\nG1 X20 Y10 E1\r\n" +
+				"; estimated printing time (normal mode) = 1d 2h 3m 4s\r\n" +
+				"; estimated printing time (silent mode) = 1d 3h 0m 0s\r\n",
+			want: Analysis{Layers: 2, EstimatedPrintTime: 26*time.Hour + 3*time.Minute + 4*time.Second},
+		},
+		{
+			name: "cura",
+			gcode: ";FLAVOR:Marlin\n;TIME:3723\n;LAYER_COUNT:3\n" +
+				";LAYER:0\nG1 X10 Y10 E1\n;LAYER:1\nG1 X20 Y10 E1\n;LAYER:2\nG1 X30 Y10 E1\n" +
+				";TIME_ELAPSED:3700.5\n",
+			want: Analysis{Layers: 3, EstimatedPrintTime: time.Hour + 2*time.Minute + 3*time.Second},
+		},
+		{
+			name: "orcaslicer",
+			gcode: "; HEADER_BLOCK_START\n; generated by OrcaSlicer 2.0.0\n" +
+				"; model printing time: 1h 2m; total estimated time: 1h 5m\n; HEADER_BLOCK_END\n" +
+				";LAYER_CHANGE\nG1 X10 Y10 E1\n",
+			want: Analysis{Layers: 1, EstimatedPrintTime: time.Hour + 5*time.Minute},
+		},
+		{
+			name: "injected",
+			gcode: injectedMarker + "\nM118 A1 status:print_start\n" +
+				";LAYER_CHANGE\nG1 X10 Y10 E1\nM118 A1 action:capture layer=0 z=0.20 ; capture\n" +
+				";LAYER_CHANGE\nG1 X20 Y10 E1\nM118 A1 action:capture layer=1 z=0.40\n" +
+				"; a comment about action:capture is not one\n",
+			want: Analysis{Layers: 2, CaptureMarkers: 2},
+		},
+		{
+			name:  "unknown slicer",
+			gcode: "G28\nG1 X10 Y10 E1\n; estimated printing time (normal mode) = soon\n",
+			want:  Analysis{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a, err := Analyze(strings.NewReader(c.gcode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a != c.want {
+				t.Errorf("analysis is %+v, want %+v", a, c.want)
+			}
+		})
+	}
+}
+
+func TestAnalysisFrames(t *testing.T) {
+	if n := (Analysis{Layers: 120}).Frames(); n != 120 {
+		t.Errorf("%d frames without capture markers, want one per layer", n)
+	}
+	if n := (Analysis{Layers: 120, CaptureMarkers: 60}).Frames(); n != 60 {
+		t.Errorf("%d frames with capture markers, want one per marker", n)
+	}
+}
+
+func TestParseSlicerDuration(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want time.Duration
+		ok   bool
+	}{
+		{"1h 2m 3s", time.Hour + 2*time.Minute + 3*time.Second, true},
+		{" 2d 0h 1m 0s ", 48*time.Hour + time.Minute, true},
+		{"45s", 45 * time.Second, true},
+		{"", 0, false},
+		{"1h 2x", 0, false},
+		{"h", 0, false},
+	} {
+		if d, ok := parseSlicerDuration(c.s); d != c.want || ok != c.ok {
+			t.Errorf("parseSlicerDuration(%q) = %s, %t, want %s, %t", c.s, d, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for _, c := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{6 * 1024 * 1024, "6.0 MiB"},
+		{1288490189, "1.2 GiB"},
+		{3 << 40, "3.0 TiB"},
+	} {
+		if s := FormatBytes(c.n); s != c.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", c.n, s, c.want)
+		}
+	}
+}
+
+func TestMakeForecast(t *testing.T) {
+	ffmpegConfig := &config.FFMPEG{
+		FramesPerSecond: "24",
+		Profiles:        map[string]config.RenderProfile{"short": {FramesPerSecond: "48"}},
+	}
+
+	outputDir := t.TempDir()
+	f := MakeForecast(Analysis{Layers: 48}, outputDir, ffmpegConfig)
+	if f.FrameSizeMeasured || f.FrameSize != DEFAULT_FRAME_SIZE {
+		t.Errorf("frame size is %d without frames, want the default", f.FrameSize)
+	}
+
+	printDir := filepath.Join(outputDir, "2024-05-01-10-00-00")
+	if err := os.Mkdir(printDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int{"frame-000001.jpg": 1000, "frame-000002.jpg": 3000, "output.mp4": 100000} {
+		if err := os.WriteFile(filepath.Join(printDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f = MakeForecast(Analysis{Layers: 48}, outputDir, ffmpegConfig)
+	if !f.FrameSizeMeasured || f.FrameSize != 2000 {
+		t.Errorf("frame size is %d, want the average of the frames", f.FrameSize)
+	}
+	if f.Frames != 48 || f.DiskUsage != 48*2000 {
+		t.Errorf("%d frames using %d bytes, want 48 using %d", f.Frames, f.DiskUsage, 48*2000)
+	}
+	if len(f.Videos) != 2 || f.Videos[0].Duration != 2*time.Second || f.Videos[1].Duration != time.Second {
+		t.Errorf("videos are %+v, want 2s then 1s long", f.Videos)
+	}
+	if !f.FitsOnDisk() {
+		t.Error("96 KB do not fit on disk")
+	}
+}
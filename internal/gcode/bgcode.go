@@ -0,0 +1,313 @@
+package gcode
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Prusa's binary G-code (`.bgcode`) starts with this, see
+// https://github.com/prusa3d/libbgcode/blob/main/doc/specifications.md
+const bgcodeMagic = "GCDE"
+
+const (
+	bgcodeBlockFileMetadata    = 0
+	bgcodeBlockGcode           = 1
+	bgcodeBlockSlicerMetadata  = 2
+	bgcodeBlockPrinterMetadata = 3
+	bgcodeBlockPrintMetadata   = 4
+	bgcodeBlockThumbnail       = 5
+)
+
+const (
+	bgcodeCompressionNone         = 0
+	bgcodeCompressionDeflate      = 1
+	bgcodeCompressionHeatshrink11 = 2
+	bgcodeCompressionHeatshrink12 = 3
+)
+
+const (
+	bgcodeEncodingNone             = 0
+	bgcodeEncodingMeatPack         = 1
+	bgcodeEncodingMeatPackComments = 2
+)
+
+func isBinaryGcode(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(bgcodeMagic))
+	return err == nil && string(magic) == bgcodeMagic
+}
+
+// Goes through the blocks of a binary G-code file, giving the G-code one
+// line at a time and the metadata as `key=value` lines.
+func readBinaryGcode(r io.Reader, onLine func(string), onMetadata func(string)) error {
+	var fileHeader struct {
+		Magic        [4]byte
+		Version      uint32
+		ChecksumType uint16
+	}
+	if err := binary.Read(r, binary.LittleEndian, &fileHeader); err != nil {
+		return fmt.Errorf("cannot read binary G-code header: %w", err)
+	}
+	checksumSize := 0
+	if fileHeader.ChecksumType == 1 {
+		// CRC32
+		checksumSize = 4
+	}
+
+	var pending string
+	for {
+		var header struct {
+			Type             uint16
+			Compression      uint16
+			UncompressedSize uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("cannot read binary G-code block: %w", err)
+		}
+		size := header.UncompressedSize
+		if header.Compression != bgcodeCompressionNone {
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("cannot read binary G-code block: %w", err)
+			}
+		}
+		paramsSize := 2
+		if header.Type == bgcodeBlockThumbnail {
+			paramsSize = 6
+		}
+
+		block := make([]byte, paramsSize+int(size)+checksumSize)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return fmt.Errorf("truncated binary G-code block: %w", err)
+		}
+		if header.Type == bgcodeBlockThumbnail {
+			continue
+		}
+		encoding := binary.LittleEndian.Uint16(block)
+		data, err := decompressBlock(block[paramsSize:paramsSize+int(size)], header.Compression, int(header.UncompressedSize))
+		if err != nil {
+			return err
+		}
+
+		switch header.Type {
+		case bgcodeBlockGcode:
+			if encoding == bgcodeEncodingMeatPack || encoding == bgcodeEncodingMeatPackComments {
+				data = unmeatpack(data)
+			}
+			lines := strings.Split(pending+string(data), "\n")
+			pending = lines[len(lines)-1]
+			for _, line := range lines[:len(lines)-1] {
+				onLine(strings.TrimRight(line, "\r"))
+			}
+		case bgcodeBlockFileMetadata, bgcodeBlockSlicerMetadata, bgcodeBlockPrinterMetadata, bgcodeBlockPrintMetadata:
+			for _, line := range strings.Split(string(data), "\n") {
+				if len(line) > 0 {
+					onMetadata(line)
+				}
+			}
+		}
+	}
+	if len(pending) > 0 {
+		onLine(pending)
+	}
+	return nil
+}
+
+func decompressBlock(data []byte, compression uint16, uncompressedSize int) ([]byte, error) {
+	switch compression {
+	case bgcodeCompressionNone:
+		return data, nil
+	case bgcodeCompressionDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case bgcodeCompressionHeatshrink11:
+		return unheatshrink(data, 11, 4, uncompressedSize)
+	case bgcodeCompressionHeatshrink12:
+		return unheatshrink(data, 12, 4, uncompressedSize)
+	default:
+		return nil, fmt.Errorf("unknown binary G-code compression %d", compression)
+	}
+}
+
+// Heatshrink is LZSS: a 1 bit tag, then either a literal byte or a back
+// reference made of an offset and a length, all packed MSB first.
+func unheatshrink(data []byte, windowBits, lookaheadBits uint, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	bits := bitReader{data: data}
+	for len(out) < size {
+		tag, ok := bits.read(1)
+		if !ok {
+			break
+		}
+		if tag == 1 {
+			literal, ok := bits.read(8)
+			if !ok {
+				break
+			}
+			out = append(out, byte(literal))
+			continue
+		}
+
+		offset, ok := bits.read(windowBits)
+		if !ok {
+			break
+		}
+		count, ok := bits.read(lookaheadBits)
+		if !ok {
+			break
+		}
+		offset++
+		count++
+		if int(offset) > len(out) {
+			return nil, errors.New("corrupted heatshrink data")
+		}
+		for i := 0; i < int(count); i++ {
+			out = append(out, out[len(out)-int(offset)])
+		}
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("corrupted heatshrink data, got %d bytes instead of %d", len(out), size)
+	}
+	return out, nil
+}
+
+type bitReader struct {
+	data []byte
+	// In bits
+	position int
+}
+
+func (b *bitReader) read(count uint) (uint32, bool) {
+	if b.position+int(count) > len(b.data)*8 {
+		return 0, false
+	}
+	var v uint32
+	for i := uint(0); i < count; i++ {
+		bit := (b.data[b.position/8] >> (7 - b.position%8)) & 1
+		v = v<<1 | uint32(bit)
+		b.position++
+	}
+	return v, true
+}
+
+// MeatPack packs the most common G-code characters two per byte.
+// Special sequences start with two 0xFF bytes.
+const (
+	meatPackSignal          = 0xFF
+	meatPackEnable          = 0xFB
+	meatPackDisable         = 0xFA
+	meatPackResetAll        = 0xF9
+	meatPackEnableNoSpaces  = 0xF7
+	meatPackDisableNoSpaces = 0xF6
+	// The character does not fit in 4 bits, it is in the next byte
+	meatPackFullChar = 0xF
+)
+
+func unmeatpack(data []byte) []byte {
+	out := make([]byte, 0, len(data)*2)
+	active, noSpaces := false, false
+	signals, commandIsNext := 0, false
+	fullChars := 0
+	var pendingChar byte
+
+	unpack := func(nibble byte) byte {
+		switch {
+		case nibble <= 9:
+			return '0' + nibble
+		case nibble == 10:
+			return '.'
+		case nibble == 11 && noSpaces:
+			return 'E'
+		case nibble == 11:
+			return ' '
+		case nibble == 12:
+			return '\n'
+		case nibble == 13:
+			return 'G'
+		default:
+			return 'X'
+		}
+	}
+
+	handle := func(c byte) {
+		if !active {
+			out = append(out, c)
+			return
+		}
+		if fullChars > 0 {
+			out = append(out, c)
+			if pendingChar != 0 {
+				out = append(out, pendingChar)
+				pendingChar = 0
+			}
+			fullChars--
+			return
+		}
+
+		low, high := c&0xF, c>>4
+		if low == meatPackFullChar {
+			fullChars++
+			if high == meatPackFullChar {
+				fullChars++
+			} else {
+				pendingChar = unpack(high)
+			}
+			return
+		}
+		first := unpack(low)
+		out = append(out, first)
+		// A line feed in the low nibble means the high one is padding
+		if first != '\n' {
+			if high == meatPackFullChar {
+				fullChars++
+			} else {
+				out = append(out, unpack(high))
+			}
+		}
+	}
+
+	for _, c := range data {
+		if c == meatPackSignal {
+			if signals > 0 {
+				commandIsNext = true
+				signals = 0
+			} else {
+				signals++
+			}
+			continue
+		}
+		if commandIsNext {
+			switch c {
+			case meatPackEnable:
+				active = true
+			case meatPackDisable:
+				active = false
+			case meatPackResetAll:
+				active, noSpaces = false, false
+			case meatPackEnableNoSpaces:
+				noSpaces = true
+			case meatPackDisableNoSpaces:
+				noSpaces = false
+			}
+			commandIsNext = false
+			continue
+		}
+		if signals > 0 {
+			handle(meatPackSignal)
+			signals = 0
+		}
+		handle(c)
+	}
+	return out
+}
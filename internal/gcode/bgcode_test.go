@@ -0,0 +1,209 @@
+package gcode
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// A binary G-code file being built, block by block.
+type bgcodeBuilder struct {
+	buf       bytes.Buffer
+	checksums bool
+}
+
+func newBgcodeBuilder(checksums bool) *bgcodeBuilder {
+	b := &bgcodeBuilder{checksums: checksums}
+	b.buf.WriteString(bgcodeMagic)
+	checksumType := uint16(0)
+	if checksums {
+		checksumType = 1
+	}
+	binary.Write(&b.buf, binary.LittleEndian, uint32(1))
+	binary.Write(&b.buf, binary.LittleEndian, checksumType)
+	return b
+}
+
+// `data` is compressed by the caller.
+func (b *bgcodeBuilder) block(blockType uint16, compression uint16, uncompressedSize int, params []byte, data []byte) {
+	var block bytes.Buffer
+	binary.Write(&block, binary.LittleEndian, blockType)
+	binary.Write(&block, binary.LittleEndian, compression)
+	binary.Write(&block, binary.LittleEndian, uint32(uncompressedSize))
+	if compression != bgcodeCompressionNone {
+		binary.Write(&block, binary.LittleEndian, uint32(len(data)))
+	}
+	block.Write(params)
+	block.Write(data)
+	if b.checksums {
+		binary.Write(&block, binary.LittleEndian, crc32.ChecksumIEEE(block.Bytes()))
+	}
+	b.buf.Write(block.Bytes())
+}
+
+func encodingParams(encoding uint16) []byte {
+	return binary.LittleEndian.AppendUint16(nil, encoding)
+}
+
+func deflate(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Heatshrink data written bit by bit, MSB first.
+type bitWriter struct {
+	data  []byte
+	count int
+}
+
+func (w *bitWriter) write(value uint32, bits uint) {
+	for i := int(bits) - 1; i >= 0; i-- {
+		if w.count%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte((value>>i)&1) << (7 - w.count%8)
+		w.count++
+	}
+}
+
+// A naive heatshrink encoder, with back references for repeats of 3 bytes
+// or more.
+func heatshrink(data string, windowBits uint, lookaheadBits uint) []byte {
+	var w bitWriter
+	maxCount := 1 << lookaheadBits
+	for i := 0; i < len(data); {
+		bestOffset, bestCount := 0, 0
+		for offset := 1; offset <= i && offset <= 1<<windowBits; offset++ {
+			count := 0
+			for count < maxCount && i+count < len(data) && data[i+count] == data[i+count-offset] {
+				count++
+			}
+			if count > bestCount {
+				bestOffset, bestCount = offset, count
+			}
+		}
+		if bestCount >= 3 {
+			w.write(0, 1)
+			w.write(uint32(bestOffset-1), windowBits)
+			w.write(uint32(bestCount-1), lookaheadBits)
+			i += bestCount
+		} else {
+			w.write(1, 1)
+			w.write(uint32(data[i]), 8)
+			i++
+		}
+	}
+	return w.data
+}
+
+func TestUnheatshrink(t *testing.T) {
+	// `a` as a literal, then 3 more copied from 1 byte back
+	data := []byte{0xb0, 0x80, 0x01, 0x00}
+	out, err := unheatshrink(data, 11, 4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "aaaa" {
+		t.Errorf("got %q, want %q", out, "aaaa")
+	}
+
+	text := "G1 X10 Y10\nG1 X10 Y20\nG1 X10 Y30\n"
+	for _, windowBits := range []uint{11, 12} {
+		out, err := unheatshrink(heatshrink(text, windowBits, 4), windowBits, 4, len(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != text {
+			t.Errorf("got %q with a %d bits window, want %q", out, windowBits, text)
+		}
+	}
+
+	if _, err := unheatshrink(data, 11, 4, 8); err == nil {
+		t.Error("no error for truncated data")
+	}
+}
+
+func TestUnmeatpack(t *testing.T) {
+	data := []byte{
+		// Enable packing
+		0xff, 0xff, meatPackEnable,
+		// `G1`, ` X`, `10`, then a line feed and padding
+		0x1d, 0xeb, 0x01, 0x0c,
+		// A full width character, then `1`
+		0x1f, 'M',
+		// A single signal byte: two full width characters
+		0xff, 'Y', 'Z',
+		// `1`, then a full width character
+		0xf1, 'Y',
+		// Without spaces, the space is `E`
+		0xff, 0xff, meatPackEnableNoSpaces,
+		0x5b, 0x0c,
+		// Disable packing
+		0xff, 0xff, meatPackDisable,
+	}
+	data = append(data, "; done\n"...)
+
+	want := "G1 X10\nM1YZ1YE5\n; done\n"
+	if out := string(unmeatpack(data)); out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestAnalyzeBinaryGcode(t *testing.T) {
+	for _, checksums := range []bool{false, true} {
+		b := newBgcodeBuilder(checksums)
+		b.block(bgcodeBlockFileMetadata, bgcodeCompressionNone, 15, encodingParams(0), []byte("Producer=tests\n"))
+		printerMetadata := "printer_model=MK4\nestimated printing time (normal mode)=1h 2m 3s\n"
+		b.block(bgcodeBlockPrinterMetadata, bgcodeCompressionDeflate, len(printerMetadata), encodingParams(0), deflate(t, printerMetadata))
+		// Thumbnails have 6 bytes of parameters
+		b.block(bgcodeBlockThumbnail, bgcodeCompressionNone, 3, make([]byte, 6), []byte("PNG"))
+
+		// Layers split across blocks, in every compression
+		first := "M73 P0\n;LAYER_CHANGE\nG1 Z0.2\n;LAY"
+		b.block(bgcodeBlockGcode, bgcodeCompressionHeatshrink11, len(first), encodingParams(bgcodeEncodingNone), heatshrink(first, 11, 4))
+		second := "ER_CHANGE\nG1 Z0.4\n"
+		b.block(bgcodeBlockGcode, bgcodeCompressionDeflate, len(second), encodingParams(bgcodeEncodingNone), deflate(t, second))
+		// `;LAYER_CHANGE` as pairs of full width characters, then a packed
+		// `G1`
+		packed := []byte{0xff, 0xff, meatPackEnable}
+		for _, pair := range []string{";L", "AY", "ER", "_C", "HA", "NG"} {
+			packed = append(packed, 0xff, pair[0], pair[1])
+		}
+		packed = append(packed, 0xcf, 'E', 0x1d, 0x0c)
+		b.block(bgcodeBlockGcode, bgcodeCompressionHeatshrink12, len(packed), encodingParams(bgcodeEncodingMeatPack), heatshrink(string(packed), 12, 4))
+
+		a, err := Analyze(&b.buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.Binary {
+			t.Error("not read as binary G-code")
+		}
+		if a.Layers != 3 {
+			t.Errorf("%d layers, want 3", a.Layers)
+		}
+		if want := time.Hour + 2*time.Minute + 3*time.Second; a.EstimatedPrintTime != want {
+			t.Errorf("estimated print time is %s, want %s", a.EstimatedPrintTime, want)
+		}
+	}
+}
+
+func TestTruncatedBinaryGcode(t *testing.T) {
+	b := newBgcodeBuilder(false)
+	gcode := "G28\nG1 X10\n"
+	b.block(bgcodeBlockGcode, bgcodeCompressionNone, len(gcode), encodingParams(bgcodeEncodingNone), []byte(gcode))
+	data := b.buf.Bytes()
+	if _, err := Analyze(bytes.NewReader(data[:len(data)-4])); err == nil {
+		t.Error("no error for a truncated file")
+	}
+}
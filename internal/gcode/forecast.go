@@ -0,0 +1,109 @@
+package gcode
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/snaps"
+	"golang.org/x/sys/unix"
+)
+
+// Used when there are no previous frames to look at, a typical DSLR JPEG.
+const DEFAULT_FRAME_SIZE = 6 * 1024 * 1024
+
+// How many of the most recent frames are looked at to guess the size of the
+// next ones.
+const frameSizeSamples = 200
+
+type VideoForecast struct {
+	Profile         string
+	FramesPerSecond string
+	Duration        time.Duration
+}
+
+// Forecast is what to expect from printing an analyzed file.
+type Forecast struct {
+	Analysis
+	Frames    int
+	FrameSize int64
+	// Whether FrameSize comes from previous frames or is DEFAULT_FRAME_SIZE
+	FrameSizeMeasured bool
+	DiskUsage         int64
+	// Free space in the output directory, zero when unknown
+	FreeSpace int64
+	Videos    []VideoForecast
+}
+
+func MakeForecast(analysis Analysis, outputDir string, ffmpegConfig *config.FFMPEG) Forecast {
+	f := Forecast{
+		Analysis:  analysis,
+		Frames:    analysis.Frames(),
+		FrameSize: DEFAULT_FRAME_SIZE,
+	}
+	if size, ok := averageFrameSize(outputDir); ok {
+		f.FrameSize = size
+		f.FrameSizeMeasured = true
+	}
+	f.DiskUsage = f.FrameSize * int64(f.Frames)
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(outputDir, &stat); err == nil {
+		f.FreeSpace = int64(stat.Bavail) * int64(stat.Bsize)
+	}
+
+	for _, profile := range ffmpegConfig.RenderProfiles() {
+		video := VideoForecast{Profile: profile.Name, FramesPerSecond: profile.FramesPerSecond}
+		if fps, err := profile.FrameRate(); err == nil {
+			video.Duration = time.Duration(float64(f.Frames) / fps * float64(time.Second))
+		}
+		f.Videos = append(f.Videos, video)
+	}
+	return f
+}
+
+// Whether the frames fit in the output directory, true when unknown.
+func (f Forecast) FitsOnDisk() bool {
+	return f.FreeSpace == 0 || f.DiskUsage < f.FreeSpace
+}
+
+func averageFrameSize(outputDir string) (int64, bool) {
+	var paths []string
+	dirs, _ := filepath.Glob(filepath.Join(outputDir, "*"))
+	for _, dir := range dirs {
+		fileNames, _ := snaps.List(dir)
+		for _, fileName := range fileNames {
+			paths = append(paths, filepath.Join(dir, fileName))
+		}
+	}
+	if len(paths) > frameSizeSamples {
+		paths = paths[len(paths)-frameSizeSamples:]
+	}
+	var total, count int64
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			total += info.Size()
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return total / count, true
+}
+
+// Human readable sizes, e.g. `1.2 GiB`.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,167 @@
+package gcode
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Where the print head goes before each capture, see the README.
+type ParkMode string
+
+const (
+	// Behind the print, centered on the first layer
+	ParkHover ParkMode = "hover"
+	// Where it is, only waiting for the moves to finish
+	ParkFreeze ParkMode = "freeze"
+	// Behind the print, at its left edge
+	ParkNextToPrint ParkMode = "next-to-print"
+	// At a fixed position
+	ParkParked ParkMode = "parked"
+)
+
+// Put at the top of injected files, so that we never inject twice.
+const injectedMarker = "; timelapse-serial: timelapse G-code injected"
+
+var ErrAlreadyInjected = errors.New("timelapse G-code was already injected in this file")
+
+type InjectOptions struct {
+	Mode ParkMode
+	// Position used by ParkParked
+	ParkX, ParkY float64
+	// Distance from the back of the print for ParkHover and ParkNextToPrint,
+	// to account for the fan duct.
+	YOffset  float64
+	Feedrate int
+	// When not zero, the printer waits up to this long for the daemon to
+	// resume it (`M0 S<seconds>`, see CaptureHandshake). Otherwise it waits
+	// for DwellMs.
+	HandshakeSeconds int
+	DwellMs          int
+}
+
+func (o InjectOptions) WithDefaults() InjectOptions {
+	if len(o.Mode) == 0 {
+		o.Mode = ParkHover
+	}
+	if o.YOffset == 0 {
+		o.YOffset = 35
+	}
+	if o.Feedrate == 0 {
+		o.Feedrate = 18000
+	}
+	if o.HandshakeSeconds == 0 && o.DwellMs == 0 {
+		o.DwellMs = 300
+	}
+	return o
+}
+
+// Rewrites a G-code file so that it drives the timelapse by itself:
+// `status:print_start` before the first layer, a capture at the end of each
+// layer and `status:print_stop` after the last one.
+// Returns the number of captures injected.
+func Inject(r io.Reader, w io.Writer, options InjectOptions) (int, error) {
+	options = options.WithDefaults()
+	switch options.Mode {
+	case ParkHover, ParkFreeze, ParkNextToPrint, ParkParked:
+	default:
+		return 0, fmt.Errorf("unknown park mode %q", options.Mode)
+	}
+
+	lines, err := readLines(r)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		if line == injectedMarker {
+			return 0, ErrAlreadyInjected
+		}
+	}
+
+	bounds := firstLayerBounds(lines)
+	if options.Mode != ParkFreeze && options.Mode != ParkParked && !bounds.valid {
+		return 0, errors.New("cannot find the extent of the first layer, use the freeze or parked mode")
+	}
+
+	lastLayerEnd := lastExtrusion(lines)
+	if lastLayerEnd < 0 {
+		return 0, errors.New("this file does not print anything")
+	}
+
+	out := bufio.NewWriter(w)
+	emit := func(s ...string) {
+		for _, line := range s {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+	}
+
+	emit(injectedMarker)
+	var state scanState
+	captures := 0
+	for i, line := range lines {
+		if isLayerChange(line) {
+			if state.layer() < 0 {
+				emit("; timelapse-serial: print start", "M118 A1 status:print_start")
+			} else {
+				emit(captureBlock(state, bounds, options)...)
+				captures++
+			}
+		}
+
+		emit(line)
+		state.update(line)
+
+		if i == lastLayerEnd && state.layer() >= 0 {
+			emit(captureBlock(state, bounds, options)...)
+			captures++
+			emit("; timelapse-serial: print stop", "M118 A1 status:print_stop")
+		}
+	}
+	return captures, out.Flush()
+}
+
+func captureBlock(state scanState, bounds bounds, options InjectOptions) []string {
+	block := []string{fmt.Sprintf("; timelapse-serial: capture layer %d (Z %.2f)", state.layer(), state.z)}
+
+	park := func(x, y float64) {
+		block = append(block, fmt.Sprintf("G0 X%.3f Y%.3f F%d", x, y, options.Feedrate))
+	}
+	switch options.Mode {
+	case ParkHover:
+		park((bounds.minX+bounds.maxX)/2, bounds.maxY+options.YOffset)
+	case ParkNextToPrint:
+		park(bounds.minX, bounds.maxY+options.YOffset)
+	case ParkParked:
+		park(options.ParkX, options.ParkY)
+	}
+
+	block = append(block,
+		"M400",
+		fmt.Sprintf("M118 A1 action:capture layer=%d z=%.2f", state.layer(), state.z),
+	)
+	if options.HandshakeSeconds > 0 {
+		block = append(block, fmt.Sprintf("M0 S%d", options.HandshakeSeconds))
+	} else {
+		block = append(block, fmt.Sprintf("G4 P%d", options.DwellMs))
+	}
+
+	// Back to where we were, so that the next move is not dragged from the
+	// park position
+	if options.Mode != ParkFreeze && state.hasPosition {
+		block = append(block, fmt.Sprintf("G0 X%.3f Y%.3f F%d", state.x, state.y, options.Feedrate))
+	}
+	return block
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	return lines, scanner.Err()
+}
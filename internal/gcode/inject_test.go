@@ -0,0 +1,239 @@
+package gcode
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Three layers as PrusaSlicer writes them, the first one spans X 10-50 and
+// Y 20-60, the second one goes beyond it.
+const slicedGcode = `; generated by PrusaSlicer 2.7.1
+G28
+G90
+M83
+;LAYER_CHANGE
+;Z:0.2
+G1 Z0.2 F720
+G1 X10 Y20 F3000
+G1 X50 Y20 E1.5
+G1 X50 Y60 E1.5
+;LAYER_CHANGE
+;Z:0.4
+G1 Z0.4
+G1 X60 Y70 E1
+;LAYER_CHANGE
+;Z:0.6
+G1 Z0.6
+G1 X30 Y40 E1
+; end gcode
+G1 X0 Y200 F3000
+M84
+; prusaslicer_config = begin`
+
+func inject(t *testing.T, gcode string, options InjectOptions) ([]string, int) {
+	t.Helper()
+	var out strings.Builder
+	captures, err := Inject(strings.NewReader(gcode), &out, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"), captures
+}
+
+// The lines of the capture of `layer`, its comment excluded.
+func captureLines(t *testing.T, lines []string, layer string) []string {
+	t.Helper()
+	start := slices.IndexFunc(lines, func(line string) bool {
+		return strings.HasPrefix(line, "; timelapse-serial: capture layer "+layer+" ")
+	})
+	if start < 0 {
+		t.Fatalf("no capture of layer %s in %q", layer, lines)
+	}
+	var block []string
+	for _, line := range lines[start+1:] {
+		if strings.HasPrefix(line, ";") {
+			break
+		}
+		block = append(block, line)
+	}
+	return block
+}
+
+func TestInjectCaptures(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		options InjectOptions
+		want    []string
+	}{
+		{
+			name:    "hover",
+			options: InjectOptions{Mode: ParkHover},
+			want: []string{
+				"G0 X30.000 Y95.000 F18000",
+				"M400",
+				"M118 A1 action:capture layer=0 z=0.20",
+				"G4 P300",
+				"G0 X50.000 Y60.000 F18000",
+			},
+		},
+		{
+			name:    "next to print",
+			options: InjectOptions{Mode: ParkNextToPrint, YOffset: 10, Feedrate: 6000},
+			want: []string{
+				"G0 X10.000 Y70.000 F6000",
+				"M400",
+				"M118 A1 action:capture layer=0 z=0.20",
+				"G4 P300",
+				"G0 X50.000 Y60.000 F6000",
+			},
+		},
+		{
+			name:    "parked",
+			options: InjectOptions{Mode: ParkParked, ParkX: 240, ParkY: 200},
+			want: []string{
+				"G0 X240.000 Y200.000 F18000",
+				"M400",
+				"M118 A1 action:capture layer=0 z=0.20",
+				"G4 P300",
+				"G0 X50.000 Y60.000 F18000",
+			},
+		},
+		{
+			name:    "freeze",
+			options: InjectOptions{Mode: ParkFreeze, DwellMs: 500},
+			want: []string{
+				"M400",
+				"M118 A1 action:capture layer=0 z=0.20",
+				"G4 P500",
+			},
+		},
+		{
+			name:    "handshake",
+			options: InjectOptions{Mode: ParkFreeze, HandshakeSeconds: 10},
+			want: []string{
+				"M400",
+				"M118 A1 action:capture layer=0 z=0.20",
+				"M0 S10",
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			lines, captures := inject(t, slicedGcode, c.options)
+			if captures != 3 {
+				t.Errorf("%d captures, want 3", captures)
+			}
+			if block := captureLines(t, lines, "0"); !slices.Equal(block, c.want) {
+				t.Errorf("capture is %q, want %q", block, c.want)
+			}
+		})
+	}
+}
+
+func TestInjectStartAndStop(t *testing.T) {
+	lines, _ := inject(t, slicedGcode, InjectOptions{Mode: ParkFreeze})
+	if lines[0] != injectedMarker {
+		t.Errorf("first line is %q, want the marker", lines[0])
+	}
+
+	// Right before the first layer
+	start := slices.Index(lines, "M118 A1 status:print_start")
+	if start < 0 || lines[start+1] != ";LAYER_CHANGE" || slices.Index(lines, ";LAYER_CHANGE") != start+1 {
+		t.Errorf("print start is not right before the first layer in %q", lines)
+	}
+
+	// The last layer is captured after its last extrusion, before the end
+	// G-code, then the print stops
+	lastExtrusion := slices.Index(lines, "G1 X30 Y40 E1")
+	if lines[lastExtrusion+1] != "; timelapse-serial: capture layer 2 (Z 0.60)" {
+		t.Errorf("line after the last extrusion is %q, want the last capture", lines[lastExtrusion+1])
+	}
+	stop := slices.Index(lines, "M118 A1 status:print_stop")
+	end := slices.Index(lines, "; end gcode")
+	if stop < lastExtrusion || stop > end {
+		t.Errorf("print stop at line %d, want between %d and %d", stop, lastExtrusion, end)
+	}
+
+	// The other captures are right before the next layer
+	for _, layer := range []string{"0", "1"} {
+		block := captureLines(t, lines, layer)
+		i := slices.IndexFunc(lines, func(line string) bool {
+			return strings.HasPrefix(line, "; timelapse-serial: capture layer "+layer+" ")
+		})
+		if next := lines[i+1+len(block)]; next != ";LAYER_CHANGE" {
+			t.Errorf("capture of layer %s is followed by %q", layer, next)
+		}
+	}
+}
+
+func TestInjectFirstLayerBounds(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		gcode string
+		want  bounds
+	}{
+		{
+			name:  "from the position before the first extrusion",
+			gcode: slicedGcode,
+			want:  bounds{minX: 10, minY: 20, maxX: 50, maxY: 60, valid: true},
+		},
+		{
+			name:  "cura",
+			gcode: ";LAYER:0\nG0 X5 Y5\nG1 X15 Y25 E1\n;LAYER:1\nG1 X100 Y100 E1\n",
+			want:  bounds{minX: 5, minY: 5, maxX: 15, maxY: 25, valid: true},
+		},
+		{
+			name:  "travel moves and the purge line before the first layer are ignored",
+			gcode: "G1 X0 Y-3 E10\n;LAYER_CHANGE\nG1 X80 Y80 F3000\nG1 X20 Y30\nG1 X40 Y30 E1\n",
+			want:  bounds{minX: 20, minY: 30, maxX: 40, maxY: 30, valid: true},
+		},
+		{
+			name:  "relative moves",
+			gcode: ";LAYER_CHANGE\nG91\nG1 X10 Y10 E1\n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			lines, _ := readLines(strings.NewReader(c.gcode))
+			if b := firstLayerBounds(lines); b != c.want {
+				t.Errorf("bounds are %+v, want %+v", b, c.want)
+			}
+		})
+	}
+}
+
+func TestInjectErrors(t *testing.T) {
+	var out strings.Builder
+	if _, err := Inject(strings.NewReader(slicedGcode), &out, InjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	injected := out.String()
+
+	for _, c := range []struct {
+		name    string
+		gcode   string
+		options InjectOptions
+		want    error
+	}{
+		{name: "already injected", gcode: injected, want: ErrAlreadyInjected},
+		{name: "unknown mode", gcode: slicedGcode, options: InjectOptions{Mode: "orbit"}},
+		{name: "no first layer", gcode: ";LAYER_CHANGE\nG91\nG1 X10 Y10 E1\n", options: InjectOptions{Mode: ParkHover}},
+		{name: "nothing printed", gcode: ";LAYER_CHANGE\nG1 X10 Y10\n", options: InjectOptions{Mode: ParkFreeze}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var out strings.Builder
+			_, err := Inject(strings.NewReader(c.gcode), &out, c.options)
+			if err == nil || (c.want != nil && !errors.Is(err, c.want)) {
+				t.Errorf("error is %v, want %v", err, c.want)
+			}
+			if out.Len() > 0 {
+				t.Error("written despite the error")
+			}
+		})
+	}
+
+	// Without the first layer's extent, only the modes which do not need it
+	if _, err := Inject(strings.NewReader(";LAYER_CHANGE\nG91\nG1 X10 Y10 E1\n"), &out, InjectOptions{Mode: ParkFreeze}); err != nil {
+		t.Errorf("freeze mode failed: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package gcode
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A G-code command without its comment, e.g. `G1 X10 Y20 E0.5`.
+type command struct {
+	name   string
+	params map[byte]float64
+}
+
+func parseCommand(line string) (command, bool) {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return command{}, false
+	}
+	cmd := command{name: strings.ToUpper(fields[0]), params: make(map[byte]float64)}
+	for _, f := range fields[1:] {
+		if len(f) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(f[1:], 64); err == nil {
+			cmd.params[strings.ToUpper(f[:1])[0]] = v
+		}
+	}
+	return cmd, true
+}
+
+// A move which lays down filament in the XY plane.
+func (c command) isExtrusion() bool {
+	_, hasX := c.params['X']
+	_, hasY := c.params['Y']
+	return c.name == "G1" && c.params['E'] > 0 && (hasX || hasY)
+}
+
+// PrusaSlicer, SuperSlicer and OrcaSlicer write `;LAYER_CHANGE`, Cura writes
+// `;LAYER:<n>`.
+func isLayerChange(line string) bool {
+	return line == ";LAYER_CHANGE" || strings.HasPrefix(line, ";LAYER:")
+}
+
+// What we know of the printer while going through the file.
+type scanState struct {
+	// Number of layer changes seen so far
+	layers int
+	z      float64
+	zKnown bool
+
+	x, y        float64
+	hasPosition bool
+	relative    bool
+}
+
+// Index of the current layer, -1 before the first one.
+func (s *scanState) layer() int {
+	return s.layers - 1
+}
+
+func (s *scanState) update(line string) {
+	if isLayerChange(line) {
+		s.layers++
+		s.zKnown = false
+		return
+	}
+	// The slicer's own idea of the layer height beats the moves, which may
+	// include Z hops.
+	if z, found := strings.CutPrefix(line, ";Z:"); found {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(z), 64); err == nil {
+			s.z = v
+			s.zKnown = true
+		}
+		return
+	}
+
+	cmd, ok := parseCommand(line)
+	if !ok {
+		return
+	}
+	switch cmd.name {
+	case "G90":
+		s.relative = false
+	case "G91":
+		s.relative = true
+		s.hasPosition = false
+	case "G0", "G1":
+		if s.relative {
+			return
+		}
+		if z, found := cmd.params['Z']; found && !s.zKnown && s.layers > 0 {
+			s.z = z
+			s.zKnown = true
+		}
+		x, hasX := cmd.params['X']
+		y, hasY := cmd.params['Y']
+		if hasX {
+			s.x = x
+		}
+		if hasY {
+			s.y = y
+		}
+		if hasX && hasY {
+			s.hasPosition = true
+		}
+	}
+}
+
+type bounds struct {
+	minX, minY, maxX, maxY float64
+	valid                  bool
+}
+
+func (b *bounds) add(x, y float64) {
+	if !b.valid {
+		*b = bounds{minX: x, maxX: x, minY: y, maxY: y, valid: true}
+		return
+	}
+	b.minX = min(b.minX, x)
+	b.maxX = max(b.maxX, x)
+	b.minY = min(b.minY, y)
+	b.maxY = max(b.maxY, y)
+}
+
+// The extent of what is printed on the first layer, like PrusaSlicer's
+// `first_layer_print_min` and `first_layer_print_max`.
+func firstLayerBounds(lines []string) bounds {
+	var b bounds
+	var state scanState
+	for _, line := range lines {
+		if isLayerChange(line) && state.layers > 0 {
+			break
+		}
+		previous := state
+		state.update(line)
+		if state.layers == 0 {
+			continue
+		}
+		if cmd, ok := parseCommand(line); ok && cmd.isExtrusion() && !state.relative {
+			if previous.hasPosition {
+				b.add(previous.x, previous.y)
+			}
+			b.add(state.x, state.y)
+		}
+	}
+	return b
+}
+
+// Index of the last line which extrudes, the end of the last layer. What
+// comes after is the end G-code and the slicer's config dump.
+func lastExtrusion(lines []string) int {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if cmd, ok := parseCommand(lines[i]); ok && cmd.isExtrusion() {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,123 @@
+// Package manifest records the metadata of every frame of a print. It does
+// not need the camera, so that the renderer can read it too.
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the file, in each snapshots directory, where the metadata of every
+// frame is appended as a JSON line.
+const FileName = "frames.jsonl"
+
+// The print job, as reported by PrusaLink.
+type Job struct {
+	ID int
+	// Name of the G-code file
+	Name string
+	// From 0 to 100
+	Progress float32
+}
+
+type Temperatures struct {
+	Nozzle       float32
+	TargetNozzle float32
+	Bed          float32
+	TargetBed    float32
+}
+
+// Metadata is what we know about a frame besides the picture itself.
+type Metadata struct {
+	// Name of the snapshots directory of the print
+	SessionID string
+	// Position of the frame in the print, starting at 1
+	Index    int
+	FileName string
+	// When the printer asked for the capture
+	RequestedAt time.Time
+	CapturedAt  time.Time
+	// Time spent in the capture queue, then by the camera
+	QueueDelayMs int64
+	LatencyMs    int64
+	// Only known when the G-code gives them, see `gcode inject`
+	Layer *int     `json:",omitempty"`
+	Z     *float64 `json:",omitempty"`
+	// Only known when PrusaLink is configured
+	Temperatures *Temperatures `json:",omitempty"`
+	Job          *Job          `json:",omitempty"`
+	// Only known when the frame analysis is enabled
+	Quality *Quality `json:",omitempty"`
+}
+
+// Quality is what the frame analyzer found about a frame, within the
+// configured region.
+type Quality struct {
+	// Variance of the Laplacian, the higher the sharper
+	Sharpness float64
+	// Mean luma, 0 to 255
+	Brightness float64
+	// Mean luma difference with the previous good frame, 0 to 255. Unknown
+	// for the first frame analyzed in a print folder.
+	Difference *float64 `json:",omitempty"`
+	Flags      []string `json:",omitempty"`
+}
+
+func Append(snapshotsDir string, m Metadata) error {
+	f, err := os.OpenFile(
+		filepath.Join(snapshotsDir, FileName),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Returns the metadata of every frame of a snapshots directory, in capture
+// order. Folders created before metadata was recorded have none.
+func Read(snapshotsDir string) ([]Metadata, error) {
+	f, err := os.Open(filepath.Join(snapshotsDir, FileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var frames []Metadata
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m Metadata
+		// A truncated last line (e.g. power loss) should not hide the rest
+		if err := json.Unmarshal(scanner.Bytes(), &m); err == nil {
+			frames = append(frames, m)
+		}
+	}
+	return frames, scanner.Err()
+}
+
+// Metadata of a single frame, by file name.
+func Find(snapshotsDir string, fileName string) (Metadata, bool) {
+	frames, _ := Read(snapshotsDir)
+	for _, m := range frames {
+		if m.FileName == fileName {
+			return m, true
+		}
+	}
+	return Metadata{}, false
+}
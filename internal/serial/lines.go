@@ -0,0 +1,28 @@
+package serial
+
+import "strings"
+
+// Reads from the port are not aligned on lines, lineSplitter holds the
+// beginning of the current line until its end is received.
+type lineSplitter struct {
+	pending strings.Builder
+}
+
+// Calls onLine for each line completed by data, without the line ending.
+func (l *lineSplitter) feed(data string, onLine func(line string)) {
+	l.pending.WriteString(data)
+	lines := strings.Split(l.pending.String(), "\n")
+	l.pending.Reset()
+	l.pending.WriteString(lines[len(lines)-1])
+
+	for _, line := range lines[:len(lines)-1] {
+		onLine(strings.TrimRight(line, "\r"))
+	}
+}
+
+// Calls onLine with what is left of an unterminated last line, if anything.
+func (l *lineSplitter) flush(onLine func(line string)) {
+	if l.pending.Len() > 0 {
+		l.feed("\n", onLine)
+	}
+}
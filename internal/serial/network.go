@@ -0,0 +1,221 @@
+package serial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const NETWORK_DIAL_TIMEOUT = 5 * time.Second
+
+// Ports exported over the network (e.g. by ser2net) are given as
+// `tcp://host:port` for a raw TCP stream, or `rfc2217://host:port` for
+// telnet with the COM port control option.
+func isNetworkPort(portName string) bool {
+	return strings.HasPrefix(portName, "tcp://") || strings.HasPrefix(portName, "rfc2217://")
+}
+
+func openNetworkPort(portName string, baudRate int) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(portName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port URL %s: %w", portName, err)
+	}
+
+	dialer := net.Dialer{Timeout: NETWORK_DIAL_TIMEOUT, KeepAlive: 15 * time.Second}
+	conn, err := dialer.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "tcp" {
+		return conn, nil
+	}
+
+	t := newTelnetConn(conn)
+	if err := t.negotiateComPort(baudRate); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Telnet commands and options, see RFC 854 and RFC 2217
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptionBinary  = 0
+	telnetOptionComPort = 44
+
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+
+	comPortParityNone = 1
+	comPortStopSize1  = 1
+)
+
+// telnetConn strips the telnet commands from what is read, and escapes the
+// data that is written.
+type telnetConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// Replies to negotiations are written from Read, while the sender may be
+	// writing too
+	writeMu sync.Mutex
+}
+
+func newTelnetConn(conn net.Conn) *telnetConn {
+	return &telnetConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Asks the server to use the serial settings of the printer: baud rate, 8N1.
+func (t *telnetConn) negotiateComPort(baudRate int) error {
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(baudRate))
+
+	commands := [][]byte{
+		{telnetIAC, telnetWILL, telnetOptionComPort},
+		{telnetIAC, telnetWILL, telnetOptionBinary},
+		{telnetIAC, telnetDO, telnetOptionBinary},
+		t.subnegotiation(comPortSetBaudRate, baud...),
+		t.subnegotiation(comPortSetDataSize, 8),
+		t.subnegotiation(comPortSetParity, comPortParityNone),
+		t.subnegotiation(comPortSetStopSize, comPortStopSize1),
+	}
+	for _, c := range commands {
+		if err := t.writeRaw(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *telnetConn) subnegotiation(command byte, value ...byte) []byte {
+	b := []byte{telnetIAC, telnetSB, telnetOptionComPort, command}
+	b = append(b, escapeIAC(value)...)
+	return append(b, telnetIAC, telnetSE)
+}
+
+func (t *telnetConn) writeRaw(b []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err := t.conn.Write(b)
+	return err
+}
+
+func escapeIAC(b []byte) []byte {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		escaped = append(escaped, c)
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+	}
+	return escaped
+}
+
+func (t *telnetConn) Write(b []byte) (int, error) {
+	if err := t.writeRaw(escapeIAC(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Returns at least one byte of data, unless there is an error.
+func (t *telnetConn) Read(b []byte) (int, error) {
+	n := 0
+	for n == 0 || (n < len(b) && t.reader.Buffered() > 0) {
+		c, err := t.reader.ReadByte()
+		if err != nil {
+			return partialRead(n, err)
+		}
+		if c != telnetIAC {
+			b[n] = c
+			n++
+			continue
+		}
+
+		command, err := t.reader.ReadByte()
+		if err != nil {
+			return partialRead(n, err)
+		}
+		switch command {
+		case telnetIAC:
+			b[n] = telnetIAC
+			n++
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			option, err := t.reader.ReadByte()
+			if err != nil {
+				return partialRead(n, err)
+			}
+			if err := t.answerNegotiation(command, option); err != nil {
+				return partialRead(n, err)
+			}
+		case telnetSB:
+			// Notifications from the server (line/modem state...), ignored
+			if err := t.skipSubnegotiation(); err != nil {
+				return partialRead(n, err)
+			}
+		}
+	}
+	return n, nil
+}
+
+// What was read before an error is returned first, the error will happen
+// again on the next read.
+func partialRead(n int, err error) (int, error) {
+	if n > 0 {
+		return n, nil
+	}
+	return 0, err
+}
+
+// Refuses every option but the ones we asked for.
+func (t *telnetConn) answerNegotiation(command byte, option byte) error {
+	if option == telnetOptionComPort || option == telnetOptionBinary {
+		return nil
+	}
+	switch command {
+	case telnetDO:
+		return t.writeRaw([]byte{telnetIAC, telnetWONT, option})
+	case telnetWILL:
+		return t.writeRaw([]byte{telnetIAC, telnetDONT, option})
+	}
+	return nil
+}
+
+func (t *telnetConn) skipSubnegotiation() error {
+	for {
+		c, err := t.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != telnetIAC {
+			continue
+		}
+		c, err = t.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c == telnetSE {
+			return nil
+		}
+	}
+}
+
+func (t *telnetConn) Close() error {
+	return t.conn.Close()
+}
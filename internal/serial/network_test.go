@@ -0,0 +1,143 @@
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Accepts a single connection on a local port, and hands it to `serve`.
+func listen(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		serve(conn)
+	}()
+	return l.Addr().String()
+}
+
+func readFull(t *testing.T, r io.Reader, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Error("read failed:", err)
+	}
+	return b
+}
+
+// Reads until `want` was received, the data may come in several reads.
+func readData(t *testing.T, port io.Reader, want []byte) {
+	t.Helper()
+	var got []byte
+	buf := make([]byte, 64)
+	for len(got) < len(want) {
+		n, err := port.Read(buf)
+		if err != nil {
+			t.Fatalf("read failed after %q: %v", got, err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func TestTcpPort(t *testing.T) {
+	received := make(chan string, 1)
+	addr := listen(t, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+		io.WriteString(conn, "ok T:21.0\n")
+	})
+
+	port, err := openPort("tcp://"+addr, 115200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if _, err := io.WriteString(port, "N1 M105*39\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line := <-received; line != "N1 M105*39\n" {
+		t.Errorf("server received %q", line)
+	}
+	readData(t, port, []byte("ok T:21.0\n"))
+}
+
+func TestRfc2217Port(t *testing.T) {
+	const baudRate = 115200
+	baud := binary.BigEndian.AppendUint32(nil, baudRate)
+	negotiation := bytes.Join([][]byte{
+		{telnetIAC, telnetWILL, telnetOptionComPort},
+		{telnetIAC, telnetWILL, telnetOptionBinary},
+		{telnetIAC, telnetDO, telnetOptionBinary},
+		append(append([]byte{telnetIAC, telnetSB, telnetOptionComPort, comPortSetBaudRate}, baud...), telnetIAC, telnetSE),
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortSetDataSize, 8, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortSetParity, comPortParityNone, telnetIAC, telnetSE},
+		{telnetIAC, telnetSB, telnetOptionComPort, comPortSetStopSize, comPortStopSize1, telnetIAC, telnetSE},
+	}, nil)
+
+	const telnetOptionEcho, telnetOptionSuppressGoAhead = 1, 3
+	fromServer := bytes.Join([][]byte{
+		[]byte("o"),
+		// Escaped data
+		{telnetIAC, telnetIAC},
+		// Refused, then accepted options
+		{telnetIAC, telnetDO, telnetOptionEcho},
+		{telnetIAC, telnetWILL, telnetOptionSuppressGoAhead},
+		{telnetIAC, telnetDO, telnetOptionComPort},
+		// A line state notification, with an escaped IAC
+		{telnetIAC, telnetSB, telnetOptionComPort, 106, telnetIAC, telnetIAC, telnetIAC, telnetSE},
+		[]byte("k\n"),
+	}, nil)
+	replies := []byte{
+		telnetIAC, telnetWONT, telnetOptionEcho,
+		telnetIAC, telnetDONT, telnetOptionSuppressGoAhead,
+	}
+
+	received := make(chan []byte, 3)
+	addr := listen(t, func(conn net.Conn) {
+		received <- readFull(t, conn, len(negotiation))
+		conn.Write(fromServer)
+		received <- readFull(t, conn, len(replies))
+		received <- readFull(t, conn, 4)
+	})
+
+	port, err := openPort("rfc2217://"+addr, baudRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	if b := <-received; !bytes.Equal(b, negotiation) {
+		t.Errorf("server received negotiation %v, want %v", b, negotiation)
+	}
+	readData(t, port, []byte{'o', telnetIAC, 'k', '\n'})
+	if b := <-received; !bytes.Equal(b, replies) {
+		t.Errorf("server received replies %v, want %v", b, replies)
+	}
+
+	// Written data is escaped
+	if n, err := port.Write([]byte{'a', telnetIAC, 'b'}); err != nil || n != 3 {
+		t.Fatalf("wrote %d bytes: %v", n, err)
+	}
+	if b, want := <-received, []byte{'a', telnetIAC, telnetIAC, 'b'}; !bytes.Equal(b, want) {
+		t.Errorf("server received %v, want %v", b, want)
+	}
+}
@@ -0,0 +1,96 @@
+package serial
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// What was read from the port, and when.
+// Recordings are made of one JSON encoded RecordedChunk per line.
+type RecordedChunk struct {
+	Time time.Time
+	Data string
+}
+
+// Recorder appends everything read from the serial port to a file, so that
+// a print can be replayed later.
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func OpenRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: f, encoder: json.NewEncoder(f)}, nil
+}
+
+// Does nothing on a nil recorder, i.e. when recording is disabled.
+func (r *Recorder) record(data string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(RecordedChunk{Time: time.Now(), Data: data}); err != nil {
+		log.Println("Cannot record serial traffic:", err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+// Feeds a recording through onRead, as if it was read from the printer.
+// The delays between the chunks are divided by speed, a speed of 0 replays
+// everything right away.
+// Files which are not recordings are taken as plain printer output, and
+// replayed line by line without delay.
+func Replay(ctx context.Context, path string, speed float64, onRead OnRead) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines lineSplitter
+	var previous time.Time
+	scanner := bufio.NewScanner(f)
+	// Recorded chunks can be as big as the read buffer once JSON encoded
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var chunk RecordedChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil || chunk.Time.IsZero() {
+			chunk = RecordedChunk{Data: scanner.Text() + "\n"}
+		}
+
+		if speed > 0 && !previous.IsZero() && !chunk.Time.IsZero() {
+			delay := time.Duration(float64(chunk.Time.Sub(previous)) / speed)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if !chunk.Time.IsZero() {
+			previous = chunk.Time
+		}
+
+		lines.feed(chunk.Data, onRead)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	// The last line may not have a line ending
+	lines.flush(onRead)
+	return nil
+}
@@ -0,0 +1,252 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait for the printer to acknowledge a command
+const GCODE_TIMEOUT = 10 * time.Second
+
+var (
+	ErrNotConnected = errors.New("printer is not connected")
+	ErrGcodeTimeout = errors.New("printer did not acknowledge the command in time")
+)
+
+type gcodeRequest struct {
+	command string
+	timeout time.Duration
+	// Sends `M110 N0` as line 0 instead of command, to reset the
+	// printer's line counter
+	resetLineNumber bool
+	// nil for fire and forget requests
+	done chan error
+}
+
+// Reply of the printer to a command, either an `ok` or a request to send
+// a line again.
+type gcodeAck struct {
+	resend bool
+	// The line to send again, -1 when it cannot be read
+	resendLine int
+}
+
+// GcodeSender writes G-code to the printer on the same serial connection we
+// read the actions from.
+// Commands are sent one at a time, each with a line number and a checksum,
+// and the next one is only sent after the printer replied `ok`, like
+// Marlin/Prusa hosts do.
+type GcodeSender struct {
+	queue chan gcodeRequest
+	acks  chan gcodeAck
+
+	// Guards everything below, which is only valid while a port is attached
+	mu         sync.Mutex
+	port       io.Writer
+	lineNumber int
+	history    map[int]string
+}
+
+func NewGcodeSender() *GcodeSender {
+	s := &GcodeSender{
+		queue: make(chan gcodeRequest, 16),
+		acks:  make(chan gcodeAck, 8),
+	}
+	go s.run()
+	return s
+}
+
+// Sends a command and waits for the printer to acknowledge it.
+// This must not be called from the serial goroutine (i.e. the OnRead
+// handler), which is the one reading the acknowledgement.
+func (s *GcodeSender) Send(command string) error {
+	return s.SendWithTimeout(command, GCODE_TIMEOUT)
+}
+
+// Same as Send, for commands which take a while to be acknowledged (e.g.
+// `M400` waits for all moves to finish).
+func (s *GcodeSender) SendWithTimeout(command string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	s.queue <- gcodeRequest{command: command, timeout: timeout, done: done}
+	return <-done
+}
+
+// Queues a command without waiting for it to be sent, it is dropped if too
+// many commands are already waiting. Safe to call from the OnRead handler.
+func (s *GcodeSender) SendAsync(command string) {
+	select {
+	case s.queue <- gcodeRequest{command: command, timeout: GCODE_TIMEOUT}:
+	default:
+		log.Println("G-code queue is full, dropping", command)
+	}
+}
+
+// Writes the command right away, without line number nor waiting for the
+// printer, bypassing the commands queued by Send/SendAsync.
+// This is meant for the commands handled by the firmware's emergency parser
+// (e.g. `M108`) which need to reach the printer while its command queue is
+// blocked. Note that the printer still acknowledges them, which may be
+// taken for the acknowledgement of the command currently being sent.
+func (s *GcodeSender) SendImmediately(command string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.port == nil {
+		return ErrNotConnected
+	}
+	_, err := io.WriteString(s.port, strings.TrimSpace(command)+"\n")
+	return err
+}
+
+// Starts writing to the port, to be called when the port was just opened.
+func (s *GcodeSender) attach(port io.Writer) {
+	s.mu.Lock()
+	s.port = port
+	s.lineNumber = 0
+	s.history = make(map[int]string)
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- gcodeRequest{resetLineNumber: true, timeout: GCODE_TIMEOUT}:
+	default:
+		log.Println("G-code queue is full, cannot reset the line number")
+	}
+}
+
+func (s *GcodeSender) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.port = nil
+}
+
+// Looks for acknowledgements in a line received from the printer.
+func (s *GcodeSender) handleLine(line string) {
+	var ack gcodeAck
+	switch {
+	case strings.HasPrefix(line, "ok"):
+	case strings.HasPrefix(line, "Resend:"):
+		ack = gcodeAck{resend: true, resendLine: parseResendLine(strings.TrimPrefix(line, "Resend:"))}
+	case strings.HasPrefix(line, "rs "):
+		ack = gcodeAck{resend: true, resendLine: parseResendLine(strings.TrimPrefix(line, "rs "))}
+	default:
+		return
+	}
+
+	select {
+	case s.acks <- ack:
+	default:
+		// Nobody is waiting for this one
+	}
+}
+
+func parseResendLine(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "N")))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+func (s *GcodeSender) run() {
+	for r := range s.queue {
+		err := s.sendAndWait(r)
+		if r.done != nil {
+			r.done <- err
+		} else if err != nil {
+			log.Printf("Cannot send G-code %q: %v\n", r.command, err)
+		}
+	}
+}
+
+func (s *GcodeSender) sendAndWait(r gcodeRequest) error {
+	// Acknowledgements of commands we gave up on are not for this one
+	for len(s.acks) > 0 {
+		<-s.acks
+	}
+
+	lineNumber, err := s.write(r)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.After(r.timeout)
+	for {
+		select {
+		case ack := <-s.acks:
+			if !ack.resend {
+				return nil
+			}
+			resendLine := ack.resendLine
+			// The command was not received either way, the current line is
+			// the best guess
+			if resendLine < 0 || resendLine > lineNumber {
+				log.Printf("Printer asked to resend an unknown line, resending line %d\n", lineNumber)
+				resendLine = lineNumber
+			}
+			if err := s.resend(resendLine); err != nil {
+				return err
+			}
+		case <-timeout:
+			return fmt.Errorf("%w: %q", ErrGcodeTimeout, r.command)
+		}
+	}
+}
+
+// Writes the command as the next line, returns its line number.
+func (s *GcodeSender) write(r gcodeRequest) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.port == nil {
+		return 0, ErrNotConnected
+	}
+
+	var lineNumber int
+	var line string
+	if r.resetLineNumber {
+		lineNumber = 0
+		line = formatGcodeLine(0, "M110 N0")
+	} else {
+		lineNumber = s.lineNumber + 1
+		line = formatGcodeLine(lineNumber, r.command)
+	}
+
+	if _, err := io.WriteString(s.port, line); err != nil {
+		return 0, err
+	}
+	s.lineNumber = lineNumber
+	s.history[lineNumber] = line
+	// The printer will only ever ask for recent lines
+	delete(s.history, lineNumber-32)
+	return lineNumber, nil
+}
+
+func (s *GcodeSender) resend(lineNumber int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.port == nil {
+		return ErrNotConnected
+	}
+	line, found := s.history[lineNumber]
+	if !found {
+		return fmt.Errorf("printer asked for line %d which we do not have anymore", lineNumber)
+	}
+	log.Println("Printer asked to resend line", lineNumber)
+	_, err := io.WriteString(s.port, line)
+	return err
+}
+
+// `N<line number> <command>*<checksum>`, where the checksum is the XOR of
+// every byte before the `*`.
+func formatGcodeLine(lineNumber int, command string) string {
+	line := fmt.Sprintf("N%d %s", lineNumber, strings.TrimSpace(command))
+	checksum := byte(0)
+	for i := 0; i < len(line); i++ {
+		checksum ^= line[i]
+	}
+	return fmt.Sprintf("%s*%d\n", line, checksum)
+}
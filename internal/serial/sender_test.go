@@ -0,0 +1,68 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+// Hands every line written to the printer over to the test.
+type linesWriter chan string
+
+func (w linesWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func nextLine(t *testing.T, written linesWriter) string {
+	t.Helper()
+	select {
+	case line := <-written:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("nothing was written")
+		return ""
+	}
+}
+
+func TestResendRequests(t *testing.T) {
+	for _, tc := range []struct {
+		reply string
+		want  string
+	}{
+		{"Resend: 1", formatGcodeLine(1, "G28")},
+		{"rs N1", formatGcodeLine(1, "G28")},
+		{"Resend: 0", formatGcodeLine(0, "M110 N0")},
+		// Unreadable or never sent, the current line is sent again
+		{"Resend: garbage", formatGcodeLine(1, "G28")},
+		{"rs N42", formatGcodeLine(1, "G28")},
+	} {
+		t.Run(tc.reply, func(t *testing.T) {
+			written := make(linesWriter, 8)
+			s := NewGcodeSender()
+			s.attach(written)
+			nextLine(t, written)
+			s.handleLine("ok")
+
+			done := make(chan error, 1)
+			go func() { done <- s.Send("G28") }()
+			if line := nextLine(t, written); line != formatGcodeLine(1, "G28") {
+				t.Fatalf("sent %q", line)
+			}
+
+			s.handleLine(tc.reply)
+			if line := nextLine(t, written); line != tc.want {
+				t.Errorf("resent %q, want %q", line, tc.want)
+			}
+			select {
+			case err := <-done:
+				t.Fatalf("Send returned %v before the command was acknowledged", err)
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			s.handleLine("ok")
+			if err := <-done; err != nil {
+				t.Error("Send failed:", err)
+			}
+		})
+	}
+}
@@ -1,76 +1,132 @@
 package serial
 
 import (
-	// "fmt"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"time"
 
+	"github.com/jochenvg/go-udev"
 	"github.com/pyrho/timelapse-serial/internal/config"
 	"go.bug.st/serial"
 )
 
-const WAIT_TIME = 5 * time.Second
+// How long to wait before reopening the port after a failure, and how often
+// the port is looked for when udev events are not available.
+const WAIT_TIME = 1 * time.Second
 
-func readFromSerial(port serial.Port, dataChan chan<- string, errChan chan<- error) {
+// Reads until the port fails, or until `done` is closed once nobody listens
+// anymore (the port is closed then, which unblocks the read).
+func readFromSerial(port io.Reader, dataChan chan<- string, errChan chan<- error, done <-chan struct{}) {
 	buf := make([]byte, 500)
 	for {
 		n, err := port.Read(buf)
 		if err != nil {
-			errChan <- err
+			select {
+			case errChan <- err:
+			case <-done:
+			}
 			return
 		}
 		if n > 0 {
-			dataChan <- string(buf[:n])
+			select {
+			case dataChan <- string(buf[:n]):
+			case <-done:
+				return
+			}
 		}
 	}
 }
 
+// Called with each line received from the printer, without the line ending.
 type OnRead func(s string)
 
-func waitForSerialPort(portName string) error {
+// Waits for the printer's port to show up, either from a udev event or by
+// checking again every WAIT_TIME in case udev does not know about it.
+// Returns the device node of the port.
+func waitForSerialPort(matcher portMatcher, events <-chan *udev.Device) (string, error) {
+	ticker := time.NewTicker(WAIT_TIME)
+	defer ticker.Stop()
+
+	loggedNotReady := false
 	for {
-		_, err := os.Stat(portName)
+		portName, err := matcher.find()
 		if err == nil {
-			log.Println("Port '" + portName + "' exists")
-			return nil
+			log.Println("Port " + matcher.String() + " exists at '" + portName + "'")
+			return portName, nil
 		}
-		if !os.IsNotExist(err) {
-			// continue
-			return fmt.Errorf("failed to stat serial port: %v", err)
+		if !errors.Is(err, errPortNotFound) {
+			return "", fmt.Errorf("failed to look for serial port: %v", err)
 		}
 
-		log.Println("Port '" + portName + "' is not ready yet, retrying")
-		time.Sleep(WAIT_TIME)
+		if !loggedNotReady {
+			log.Println("Port " + matcher.String() + " is not ready yet, waiting for it")
+			loggedNotReady = true
+		}
+		select {
+		case <-events:
+		case <-ticker.C:
+		}
 	}
 }
 
-func StartSerialLoop(conf *config.Config, onRead OnRead) {
+func StartSerialLoop(conf *config.Config, sender *GcodeSender, onRead OnRead) {
+	matcher := newPortMatcher(&conf.Printer)
+
+	var recorder *Recorder
+	if len(conf.Printer.RecordPath) > 0 {
+		var err error
+		if recorder, err = OpenRecorder(conf.Printer.RecordPath); err != nil {
+			log.Printf("Cannot record serial traffic: %v\n", err)
+		} else {
+			log.Println("Recording serial traffic to", conf.Printer.RecordPath)
+		}
+	}
+
+	// A nil channel is fine, we just won't get notified and will rely
+	// on polling and read errors instead.
+	events, err := ttyEvents(context.Background())
+	if err != nil {
+		log.Printf("Cannot monitor serial port events, falling back to polling: %v\n", err)
+	}
 
 	for {
-		if err := waitForSerialPort(conf.Printer.PortName); err != nil {
+		portName, err := waitForSerialPort(matcher, events)
+		if err != nil {
 			log.Printf("Error: %v\n", err)
 			return
 		}
 
 		log.Println("Serial port is now available")
 
-		if err := openAndRead(conf, onRead); err != nil {
+		if err := openAndRead(conf, portName, events, sender, recorder, onRead); err != nil {
 			log.Printf("Error: %v\n", err)
 		}
-		// Wait for a second before retrying the port
+		// Wait a bit before retrying the port
 		time.Sleep(WAIT_TIME)
 	}
 
 }
 
-func openAndRead(conf *config.Config, onRead OnRead) error {
-	// Open the serial port
-	mode := &serial.Mode{
-		BaudRate: conf.Printer.BaudRate,
+// Opens either a local serial port, or one exported over the network.
+func openPort(portName string, baudRate int) (io.ReadWriteCloser, error) {
+	if isNetworkPort(portName) {
+		return openNetworkPort(portName, baudRate)
 	}
-	port, err := serial.Open(conf.Printer.PortName, mode)
+	return serial.Open(portName, &serial.Mode{BaudRate: baudRate})
+}
+
+func openAndRead(
+	conf *config.Config,
+	portName string,
+	events <-chan *udev.Device,
+	sender *GcodeSender,
+	recorder *Recorder,
+	onRead OnRead,
+) error {
+	port, err := openPort(portName, conf.Printer.BaudRate)
 
 	if err != nil {
 		return fmt.Errorf("Error opening serial port: %v", err)
@@ -80,23 +136,40 @@ func openAndRead(conf *config.Config, onRead OnRead) error {
 
 	log.Println("Serial port opened successfully")
 
+	sender.attach(port)
+	defer sender.detach()
+
 	// Create channels to handle data and errors
 	dataChan := make(chan string)
 	errChan := make(chan error)
+	// Closed before the port, so that the reader exits whichever way we return
+	done := make(chan struct{})
+	defer close(done)
 
 	log.Println("Ready...")
 	// Start a goroutine to read from the serial port
-	go readFromSerial(port, dataChan, errChan)
+	go readFromSerial(port, dataChan, errChan, done)
+
+	var lines lineSplitter
 
 	// Main loop to handle incoming data
 	for {
 		select {
 		case data := <-dataChan:
 			// log.Printf("Received: %s", data)
-			onRead(data)
+			recorder.record(data)
+			lines.feed(data, func(line string) {
+				sender.handleLine(line)
+				onRead(line)
+			})
 
 		case err := <-errChan:
 			return fmt.Errorf("Error: %v", err)
+
+		case dev := <-events:
+			if dev.Action() == "remove" && dev.Devnode() == portName {
+				return fmt.Errorf("serial port %s was removed", portName)
+			}
 		}
 	}
 }
@@ -1,12 +1,16 @@
 package serial
 
 import (
+	"fmt"
 	"log"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pyrho/timelapse-serial/internal/camera"
+	"github.com/pyrho/timelapse-serial/internal/capture"
 	"github.com/pyrho/timelapse-serial/internal/config"
-	"github.com/pyrho/timelapse-serial/internal/ffmpeg"
 )
 
 const (
@@ -16,29 +20,107 @@ const (
 	COMMAND_UNHANDLED
 )
 
-func CreateSerialMessageHandler(cam camera.CameraWrapperInterface, ffmpegConfig *config.FFMPEG) func(m string) {
+// Starts the rendering of the timelapse of a snapshots directory once the
+// print is done. It is called on the capture worker, so it must not block.
+type Renderer func(snapshotsDir string)
+
+// The camera is only ever driven through the capture worker, so that
+// reading from the serial port is never blocked by the camera and the
+// captures stay in order with the start/stop of the print.
+func CreateSerialMessageHandler(
+	cam camera.CameraWrapperInterface,
+	worker *capture.Worker,
+	sender *GcodeSender,
+	printerConfig *config.Printer,
+	render Renderer,
+) func(m string) {
+	handshake := printerConfig.WithDefaults()
 	return func(message string) {
+		receivedAt := time.Now()
 
 		switch command := parseCommand(message); command {
 
 		case COMMAND_PRINT_START:
 			log.Println("New print started")
-			cam.Start()
-			cam.CreateNewSnapshotsDir()
-			log.Println("New photo directory created")
+			worker.Do(func() {
+				cam.Start()
+				cam.CreateNewSnapshotsDir()
+				log.Println("New photo directory created")
+			})
 
 		case COMMAND_CAPTURE:
 			log.Println("Capturing...")
-			cam.Snap()
+			var onDone func(capture.Result)
+			if handshake.CaptureHandshake {
+				onDone = resumePrinterAfterCapture(sender, handshake)
+			}
+			if err := worker.EnqueueCapture(receivedAt, parseActionParams(message), onDone); err != nil {
+				log.Println("Capture request dropped:", err)
+				if onDone != nil {
+					onDone(capture.Result{Err: err})
+				}
+			}
 
 		case COMMAND_PRINT_STOP:
 			log.Println("Print stopped")
-			cam.Stop()
-			log.Println("Print done, creating timelapse...")
-			go ffmpeg.SpawnFFMPEG(cam.GetCurrentSnapshotsDir(), ffmpegConfig.WithDefaults())
+			worker.Do(func() {
+				cam.Stop()
+				render(cam.GetCurrentSnapshotsDir())
+			})
+		}
+
+	}
+}
+
+// In handshake mode the printer waits (`M0 S<timeout>`) after asking for a
+// capture, until we tell it to resume.
+// Returns the function to call once the capture is done. The printer is
+// resumed at the latest after `HandshakeTimeoutInSeconds`, so that a stuck
+// camera never stalls the print.
+func resumePrinterAfterCapture(sender *GcodeSender, printerConfig config.Printer) func(capture.Result) {
+	resume := sync.OnceFunc(func() {
+		if err := sender.SendImmediately(printerConfig.ResumeCommand); err != nil {
+			log.Println("Cannot resume the printer:", err)
 		}
+	})
+	timeout := time.AfterFunc(time.Duration(printerConfig.HandshakeTimeoutInSeconds)*time.Second, func() {
+		log.Println("Capture is taking too long, resuming the printer anyway")
+		resume()
+	})
 
+	return func(r capture.Result) {
+		timeout.Stop()
+		if r.Err != nil {
+			log.Println("Capture failed, resuming the printer anyway")
+		}
+		resume()
+	}
+}
+
+// Shows the progress of the timelapse on the printer's display.
+func CreateStatusMessagePostProcessor(sender *GcodeSender) capture.PostProcessor {
+	return func(r capture.Result) {
+		if r.Err != nil {
+			sender.SendAsync("M117 Timelapse capture failed")
+			return
+		}
+		stats := camera.GetCaptureStats(filepath.Dir(r.SnapPath))
+		sender.SendAsync(fmt.Sprintf("M117 Frame %d captured", stats.Total-stats.Failed))
+	}
+}
+
+// `// action:capture layer=3 z=0.80` has the parameters `layer` and `z`,
+// see `gcode inject`.
+func parseActionParams(incomingMessage string) map[string]string {
+	fields := strings.Fields(incomingMessage)
+	params := make(map[string]string)
+	// Skipping `//` and the action itself
+	for _, f := range fields[min(2, len(fields)):] {
+		if key, value, found := strings.Cut(f, "="); found && len(key) > 0 {
+			params[key] = value
+		}
 	}
+	return params
 }
 
 func parseCommand(incomingMessage string) int {
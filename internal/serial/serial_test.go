@@ -0,0 +1,41 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReaderExitsWhenNobodyListens(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// Makes the reader block on a send
+		feed func(w *io.PipeWriter)
+	}{
+		{"data", func(w *io.PipeWriter) { w.Write([]byte("ok\n")) }},
+		{"error", func(w *io.PipeWriter) { w.CloseWithError(errors.New("unplugged")) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, w := io.Pipe()
+			done := make(chan struct{})
+			exited := make(chan struct{})
+			go func() {
+				readFromSerial(r, make(chan string), make(chan error), done)
+				close(exited)
+			}()
+
+			go tc.feed(w)
+			// Let the reader block on its send before giving up on it
+			time.Sleep(10 * time.Millisecond)
+			close(done)
+			r.Close()
+
+			select {
+			case <-exited:
+			case <-time.After(time.Second):
+				t.Fatal("the reader is still running")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jochenvg/go-udev"
+	"github.com/pyrho/timelapse-serial/internal/config"
+)
+
+var errPortNotFound = errors.New("serial port not found")
+
+// portMatcher recognizes the printer among the tty devices known to udev,
+// either by its USB vendor/serial number, or by its device path.
+type portMatcher struct {
+	portName     string
+	vendorID     string
+	serialNumber string
+}
+
+func newPortMatcher(conf *config.Printer) portMatcher {
+	return portMatcher{
+		portName:     conf.PortName,
+		vendorID:     conf.VendorID,
+		serialNumber: conf.SerialNumber,
+	}
+}
+
+func (m portMatcher) byUsbID() bool {
+	return len(m.vendorID) > 0 || len(m.serialNumber) > 0
+}
+
+func (m portMatcher) String() string {
+	if m.byUsbID() {
+		return "USB device " + m.vendorID + ":" + m.serialNumber
+	}
+	return "'" + m.portName + "'"
+}
+
+func (m portMatcher) matches(d *udev.Device) bool {
+	if m.byUsbID() {
+		return (len(m.vendorID) == 0 || strings.EqualFold(d.PropertyValue("ID_VENDOR_ID"), m.vendorID)) &&
+			(len(m.serialNumber) == 0 || d.PropertyValue("ID_SERIAL_SHORT") == m.serialNumber)
+	}
+	if d.Devnode() == m.portName {
+		return true
+	}
+	_, isLink := d.Devlinks()[m.portName]
+	return isLink
+}
+
+// Returns the device node of the printer's port, `/dev/serial/by-id/...`
+// names are resolved to the actual `/dev/ttyXXX` device.
+func (m portMatcher) find() (string, error) {
+	if isNetworkPort(m.portName) {
+		// Whether it is there is only known when connecting
+		return m.portName, nil
+	}
+	if !m.byUsbID() {
+		// Not every port is known to udev (e.g. PTYs), so just check that
+		// the path exists
+		resolved, err := filepath.EvalSymlinks(m.portName)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", errPortNotFound
+		}
+		return resolved, err
+	}
+
+	u := udev.Udev{}
+	e := u.NewEnumerate()
+	if err := e.AddMatchSubsystem("tty"); err != nil {
+		return "", err
+	}
+	if err := e.AddMatchIsInitialized(); err != nil {
+		return "", err
+	}
+	devices, err := e.Devices()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		if len(d.Devnode()) > 0 && m.matches(d) {
+			return d.Devnode(), nil
+		}
+	}
+	return "", errPortNotFound
+}
+
+// Streams the udev events of the tty subsystem, i.e. serial ports appearing
+// and disappearing.
+func ttyEvents(ctx context.Context) (<-chan *udev.Device, error) {
+	u := udev.Udev{}
+	m := u.NewMonitorFromNetlink("udev")
+	if err := m.FilterAddMatchSubsystem("tty"); err != nil {
+		return nil, err
+	}
+	return m.DeviceChan(ctx)
+}
@@ -0,0 +1,72 @@
+package simulator
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Pty is a pseudo-terminal pair: the simulated printer reads and writes the
+// master side, the daemon opens the slave side as if it was a serial port.
+type Pty struct {
+	Master *os.File
+	// Kept open so that reading the master does not fail while the daemon
+	// is not connected.
+	slave     *os.File
+	SlavePath string
+}
+
+func OpenPty() (*Pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("cannot unlock pty: %w", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("cannot get pty number: %w", err)
+	}
+
+	slavePath := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	// Like a serial port: no echo, no line editing, no newline translation
+	if err := makeRaw(int(slave.Fd())); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, err
+	}
+
+	return &Pty{Master: master, slave: slave, SlavePath: slavePath}, nil
+}
+
+func (p *Pty) Close() error {
+	p.slave.Close()
+	return p.Master.Close()
+}
+
+// Same as cfmakeraw(3)
+func makeRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
@@ -0,0 +1,160 @@
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	Layers    int
+	LayerTime time.Duration
+	// How often temperatures are reported
+	TemperatureInterval time.Duration
+	// Answer the G-code sent by the daemon with `ok` (and `echo:` for
+	// `M117`). Otherwise `ok` lines are sent along with the temperatures.
+	RespectGcode bool
+	// When not zero, the printer waits up to this long after each capture
+	// request, until it receives `M108` (see CaptureHandshake).
+	Handshake time.Duration
+}
+
+// Printer emits what a Prusa/Marlin printer running a timelapse-enabled
+// G-code prints on its serial port.
+type Printer struct {
+	options Options
+
+	writeMu sync.Mutex
+	out     io.Writer
+
+	// Signaled when `M108` is received
+	resume chan struct{}
+
+	bedTemp, nozzleTemp float64
+}
+
+func NewPrinter(out io.Writer, options Options) *Printer {
+	return &Printer{
+		options:    options,
+		out:        out,
+		resume:     make(chan struct{}, 1),
+		bedTemp:    60,
+		nozzleTemp: 215,
+	}
+}
+
+func (p *Printer) println(line string) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if _, err := io.WriteString(p.out, line+"\n"); err != nil {
+		log.Println("Cannot write to the pty:", err)
+	}
+}
+
+// Runs the print: start, one capture per layer, stop.
+func (p *Printer) Print(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go p.reportTemperatures(ctx)
+
+	p.println("echo:enqueing \"M118 A1 status:print_start\"")
+	p.println("// status:print_start")
+	for layer := 1; layer <= p.options.Layers; layer++ {
+		select {
+		case <-time.After(p.options.LayerTime):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		log.Printf("Layer %d/%d\n", layer, p.options.Layers)
+		p.println("// action:capture")
+		if p.options.Handshake > 0 {
+			p.waitForResume(ctx)
+		}
+	}
+	p.println("// status:print_stop")
+	return nil
+}
+
+// Like `M0 S<seconds>`
+func (p *Printer) waitForResume(ctx context.Context) {
+	// A resume sent before we started waiting is not for this capture
+	select {
+	case <-p.resume:
+	default:
+	}
+
+	start := time.Now()
+	select {
+	case <-p.resume:
+		log.Printf("Resumed after %s\n", time.Since(start).Round(time.Millisecond))
+	case <-time.After(p.options.Handshake):
+		log.Println("Not resumed, carrying on after", p.options.Handshake)
+	case <-ctx.Done():
+	}
+}
+
+func (p *Printer) reportTemperatures(ctx context.Context) {
+	ticker := time.NewTicker(p.options.TemperatureInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		nozzle := p.nozzleTemp + rand.Float64() - 0.5
+		bed := p.bedTemp + (rand.Float64()-0.5)/5
+		report := fmt.Sprintf("T:%.2f/%.2f B:%.2f/%.2f T0:%.2f/%.2f @:%d B@:%d",
+			nozzle, p.nozzleTemp, bed, p.bedTemp, nozzle, p.nozzleTemp, 60+rand.Intn(10), 20+rand.Intn(10))
+		if p.options.RespectGcode {
+			p.println(" " + report)
+		} else {
+			// What a host polling with M105 would get
+			p.println("ok " + report)
+		}
+	}
+}
+
+var gcodeLine = regexp.MustCompile(`^(?:N\d+\s+)?([^*]*)(?:\*\d+)?$`)
+
+// Reads the G-code sent by the daemon, and answers it when RespectGcode is
+// set. `M108` resumes a waiting print either way, like the emergency parser.
+func (p *Printer) ReadGcode(ctx context.Context, in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		command := line
+		if m := gcodeLine.FindStringSubmatch(line); m != nil {
+			command = strings.TrimSpace(m[1])
+		}
+		log.Println("Received:", line)
+
+		if strings.HasPrefix(command, "M108") {
+			select {
+			case p.resume <- struct{}{}:
+			default:
+			}
+		}
+		if !p.options.RespectGcode {
+			continue
+		}
+		if message, found := strings.CutPrefix(command, "M117"); found {
+			p.println("echo:" + strings.TrimSpace(message))
+		}
+		p.println("ok")
+	}
+}
@@ -0,0 +1,109 @@
+package snaps
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+// Name of the file, in each snapshots directory, listing the frames which
+// are left out of the videos, one file name per line. The frames themselves
+// are kept.
+const EXCLUSIONS_FILE_NAME = "excluded.txt"
+
+// Guards the exclusion lists
+var exclusionsMu sync.Mutex
+
+// The frames of a snapshots directory which are left out of the videos.
+func Excluded(snapshotsDir string) (map[string]bool, error) {
+	exclusionsMu.Lock()
+	defer exclusionsMu.Unlock()
+	return readExclusions(snapshotsDir)
+}
+
+// Adds a frame to the exclusion list of its directory, or removes it.
+func SetExcluded(snapshotsDir string, fileName string, excluded bool) error {
+	if !IsFrame(fileName) {
+		return errors.New("not a frame: " + fileName)
+	}
+	exclusionsMu.Lock()
+	defer exclusionsMu.Unlock()
+
+	exclusions, err := readExclusions(snapshotsDir)
+	if err != nil {
+		return err
+	}
+	if exclusions[fileName] == excluded {
+		return nil
+	}
+	if excluded {
+		exclusions[fileName] = true
+	} else {
+		delete(exclusions, fileName)
+	}
+	return writeExclusions(snapshotsDir, exclusions)
+}
+
+func readExclusions(snapshotsDir string) (map[string]bool, error) {
+	exclusions := make(map[string]bool)
+	content, err := os.ReadFile(filepath.Join(snapshotsDir, EXCLUSIONS_FILE_NAME))
+	if errors.Is(err, fs.ErrNotExist) {
+		return exclusions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if fileName := strings.TrimSpace(scanner.Text()); len(fileName) > 0 {
+			exclusions[fileName] = true
+		}
+	}
+	return exclusions, scanner.Err()
+}
+
+func writeExclusions(snapshotsDir string, exclusions map[string]bool) error {
+	fileNames := make([]string, 0, len(exclusions))
+	for fileName := range exclusions {
+		fileNames = append(fileNames, fileName)
+	}
+	slices.SortFunc(fileNames, func(a, b string) int {
+		nameA, _ := Parse(a)
+		nameB, _ := Parse(b)
+		return cmp.Or(compareNames(nameA, nameB), strings.Compare(a, b))
+	})
+	var out bytes.Buffer
+	for _, fileName := range fileNames {
+		out.WriteString(fileName)
+		out.WriteByte('\n')
+	}
+	return utils.WriteFileAtomically(filepath.Join(snapshotsDir, EXCLUSIONS_FILE_NAME), out.Bytes())
+}
+
+// Follows the frames renamed by Migrate.
+func renameExclusions(snapshotsDir string, renames map[string]string) error {
+	exclusionsMu.Lock()
+	defer exclusionsMu.Unlock()
+
+	exclusions, err := readExclusions(snapshotsDir)
+	if err != nil || len(exclusions) == 0 {
+		return err
+	}
+	renamed := make(map[string]bool)
+	for fileName := range exclusions {
+		if newName, found := renames[fileName]; found {
+			fileName = newName
+		}
+		renamed[fileName] = true
+	}
+	return writeExclusions(snapshotsDir, renamed)
+}
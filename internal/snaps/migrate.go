@@ -0,0 +1,210 @@
+package snaps
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+// Name of the file, in a snapshots directory being migrated, recording what
+// is renamed and how far it went, so that an interrupted migration can be
+// resumed instead of leaving the directory half renamed.
+const MIGRATION_JOURNAL_FILE_NAME = ".migration.json"
+
+// Files are first moved to a temporary name, since renumbering may give a
+// frame the name of another one.
+const migrationTmpPrefix = ".migrating-"
+
+const (
+	migrationStageTmpNames = iota
+	migrationStageFinalNames
+	migrationStageFiles
+)
+
+type migrationJournal struct {
+	// Frames, old name to new name
+	Renames map[string]string
+	// Every file to move: the frames and their thumbnails and sidecars
+	Moves map[string]string
+	Stage int
+	// The logs and the exclusion list which are up to date already
+	Updated []string
+}
+
+// Renames the frames of a snapshots directory holding legacy names to
+// sequence numbers, in capture order, along with their thumbnails, sidecars
+// and in the exclusion list.
+// `logFiles` are the JSON lines files of the directory (capture outcomes,
+// frame manifest) whose `FileName` fields are updated accordingly.
+// Directories without legacy frames are left untouched, those where a
+// migration was interrupted are migrated to the end.
+// Returns the renamed frames, old name to new name; with `dryRun` nothing is
+// renamed.
+func Migrate(snapshotsDir string, dryRun bool, logFiles ...string) (map[string]string, error) {
+	journalPath := filepath.Join(snapshotsDir, MIGRATION_JOURNAL_FILE_NAME)
+	journal, err := readMigrationJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	if journal == nil {
+		renames, err := plannedRenames(snapshotsDir)
+		if err != nil || len(renames) == 0 || dryRun {
+			return renames, err
+		}
+		journal = &migrationJournal{Renames: renames, Moves: make(map[string]string)}
+		for oldName, newName := range renames {
+			journal.Moves[oldName] = newName
+			for _, related := range []func(string) string{ThumbnailName, XMPSidecarName} {
+				if _, err := os.Stat(filepath.Join(snapshotsDir, related(oldName))); err == nil {
+					journal.Moves[related(oldName)] = related(newName)
+				}
+			}
+		}
+		if err := writeMigrationJournal(journalPath, journal); err != nil {
+			return nil, err
+		}
+	} else if dryRun {
+		return journal.Renames, nil
+	}
+
+	if journal.Stage == migrationStageTmpNames {
+		for oldName, newName := range journal.Moves {
+			// Already moved when resuming
+			if _, err := os.Stat(filepath.Join(snapshotsDir, oldName)); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err := os.Rename(filepath.Join(snapshotsDir, oldName), filepath.Join(snapshotsDir, migrationTmpPrefix+newName)); err != nil {
+				return nil, err
+			}
+		}
+		journal.Stage = migrationStageFinalNames
+		if err := writeMigrationJournal(journalPath, journal); err != nil {
+			return nil, err
+		}
+	}
+
+	if journal.Stage == migrationStageFinalNames {
+		for _, newName := range journal.Moves {
+			tmpPath := filepath.Join(snapshotsDir, migrationTmpPrefix+newName)
+			if _, err := os.Stat(tmpPath); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err := os.Rename(tmpPath, filepath.Join(snapshotsDir, newName)); err != nil {
+				return nil, err
+			}
+		}
+		journal.Stage = migrationStageFiles
+		if err := writeMigrationJournal(journalPath, journal); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range append(logFiles, EXCLUSIONS_FILE_NAME) {
+		if slices.Contains(journal.Updated, file) {
+			continue
+		}
+		if file == EXCLUSIONS_FILE_NAME {
+			err = renameExclusions(snapshotsDir, journal.Renames)
+		} else {
+			err = renameInLog(filepath.Join(snapshotsDir, file), journal.Renames)
+		}
+		if err != nil {
+			return journal.Renames, err
+		}
+		journal.Updated = append(journal.Updated, file)
+		if err := writeMigrationJournal(journalPath, journal); err != nil {
+			return journal.Renames, err
+		}
+	}
+	return journal.Renames, os.Remove(journalPath)
+}
+
+// The new name of every frame which is renamed, none when the directory has
+// no legacy frames.
+func plannedRenames(snapshotsDir string) (map[string]string, error) {
+	fileNames, err := List(snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	hasLegacy := false
+	for _, fileName := range fileNames {
+		if name, _ := Parse(fileName); name.Legacy() {
+			hasLegacy = true
+		}
+	}
+	if !hasLegacy {
+		return nil, nil
+	}
+
+	renames := make(map[string]string)
+	for i, fileName := range fileNames {
+		if newName := FileName(i + 1); newName != fileName {
+			renames[fileName] = newName
+		}
+	}
+	return renames, nil
+}
+
+// nil when no migration was interrupted.
+func readMigrationJournal(path string) (*migrationJournal, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var journal migrationJournal
+	if err := json.Unmarshal(content, &journal); err != nil {
+		return nil, fmt.Errorf("corrupted migration journal %s: %w", path, err)
+	}
+	return &journal, nil
+}
+
+func writeMigrationJournal(path string, journal *migrationJournal) error {
+	content, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(path, content)
+}
+
+func renameInLog(path string, renames map[string]string) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var entry map[string]json.RawMessage
+		var fileName string
+		if json.Unmarshal(line, &entry) == nil && json.Unmarshal(entry["FileName"], &fileName) == nil {
+			if newName, found := renames[fileName]; found {
+				entry["FileName"], _ = json.Marshal(newName)
+				if updated, err := json.Marshal(entry); err == nil {
+					line = updated
+				}
+			}
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomically(path, out.Bytes())
+}
@@ -0,0 +1,153 @@
+package snaps
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+// Zero padding of the sequence number, so that names sort like numbers
+const SEQUENCE_DIGITS = 6
+
+var (
+	sequenceName = regexp.MustCompile(`^frame-([0-9]+)\.jpg$`)
+	legacyName   = regexp.MustCompile(`^snap([0-9]+)\.jpg$`)
+)
+
+// Name is a parsed frame file name.
+type Name struct {
+	// Position in the print starting at 1, 0 for legacy names
+	Sequence int
+	// Unix time of the capture, only for legacy names
+	Timestamp int64
+}
+
+func (n Name) Legacy() bool {
+	return n.Sequence == 0
+}
+
+// Frames are named after their position in the print, e.g.
+// `frame-000001.jpg`. They used to be named after the Unix time of their
+// capture (`snap1700000000.jpg`), which made two captures within the same
+// second overwrite each other. Such folders are still understood everywhere,
+// and can be renamed with the `migrate-frames` command.
+func FileName(sequence int) string {
+	return fmt.Sprintf("frame-%0*d.jpg", SEQUENCE_DIGITS, sequence)
+}
+
+func Parse(fileName string) (Name, bool) {
+	if m := sequenceName.FindStringSubmatch(fileName); m != nil {
+		sequence, err := strconv.Atoi(m[1])
+		return Name{Sequence: sequence}, err == nil && sequence > 0
+	}
+	if m := legacyName.FindStringSubmatch(fileName); m != nil {
+		timestamp, err := strconv.ParseInt(m[1], 10, 64)
+		return Name{Timestamp: timestamp}, err == nil
+	}
+	return Name{}, false
+}
+
+func IsFrame(fileName string) bool {
+	_, ok := Parse(fileName)
+	return ok
+}
+
+// Legacy frames come first since they can only be older than the numbered
+// ones, then each scheme is in capture order.
+func compareNames(a, b Name) int {
+	if a.Legacy() != b.Legacy() {
+		if a.Legacy() {
+			return -1
+		}
+		return 1
+	}
+	if a.Legacy() {
+		return cmp.Compare(a.Timestamp, b.Timestamp)
+	}
+	return cmp.Compare(a.Sequence, b.Sequence)
+}
+
+// Returns the file names of the frames of a snapshots directory, in capture
+// order.
+func List(snapshotsDir string) ([]string, error) {
+	entries, err := os.ReadDir(snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	type frame struct {
+		fileName string
+		name     Name
+	}
+	var frames []frame
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if name, ok := Parse(entry.Name()); ok {
+			frames = append(frames, frame{entry.Name(), name})
+		}
+	}
+	slices.SortStableFunc(frames, func(a, b frame) int {
+		return compareNames(a.name, b.name)
+	})
+
+	fileNames := make([]string, len(frames))
+	for i, f := range frames {
+		fileNames[i] = f.fileName
+	}
+	return fileNames, nil
+}
+
+// Name of the file, in each snapshots directory, holding the sequence number
+// of the last frame.
+const SEQUENCE_FILE_NAME = ".sequence"
+
+// Reserves the sequence number of the next frame of a snapshots directory.
+// Numbers are never reused: when the last frame is deleted, the next one
+// must not get its metadata and thumbnail, which are recorded by file name.
+func NextSequence(snapshotsDir string) (int, error) {
+	entries, err := os.ReadDir(snapshotsDir)
+	if err != nil {
+		return 0, err
+	}
+	// Folders from older versions have no sequence file
+	last := 0
+	if content, err := os.ReadFile(filepath.Join(snapshotsDir, SEQUENCE_FILE_NAME)); err == nil {
+		last, _ = strconv.Atoi(strings.TrimSpace(string(content)))
+	}
+	for _, entry := range entries {
+		if name, ok := Parse(entry.Name()); ok {
+			last = max(last, name.Sequence)
+		}
+	}
+	next := last + 1
+	if err := utils.WriteFileAtomically(filepath.Join(snapshotsDir, SEQUENCE_FILE_NAME), []byte(strconv.Itoa(next)+"\n")); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// `snap1700000000.jpg` has the thumbnail `thumb1700000000.jpg`,
+// `frame-000001.jpg` has `thumb-000001.jpg`.
+func ThumbnailName(fileName string) string {
+	if m := sequenceName.FindStringSubmatch(fileName); m != nil {
+		return "thumb-" + m[1] + ".jpg"
+	}
+	if m := legacyName.FindStringSubmatch(fileName); m != nil {
+		return "thumb" + m[1] + ".jpg"
+	}
+	return "thumb-" + fileName
+}
+
+// `frame-000001.jpg` has its metadata in `frame-000001.xmp` when it cannot be
+// embedded in the picture.
+func XMPSidecarName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".xmp"
+}
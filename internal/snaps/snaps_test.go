@@ -0,0 +1,170 @@
+package snaps
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestNextSequenceIsNeverReused(t *testing.T) {
+	dir := t.TempDir()
+	for want := 1; want <= 3; want++ {
+		sequence, err := NextSequence(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sequence != want {
+			t.Fatalf("sequence %d, want %d", sequence, want)
+		}
+		writeFiles(t, dir, map[string]string{FileName(sequence): "frame"})
+	}
+
+	if err := os.Remove(filepath.Join(dir, FileName(3))); err != nil {
+		t.Fatal(err)
+	}
+	if sequence, _ := NextSequence(dir); sequence != 4 {
+		t.Errorf("sequence %d after deleting the last frame, want 4", sequence)
+	}
+}
+
+func TestNextSequenceOfOlderFolders(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{FileName(7): "frame", "snap1700000000.jpg": "frame"})
+	if sequence, _ := NextSequence(dir); sequence != 8 {
+		t.Errorf("sequence %d, want 8", sequence)
+	}
+}
+
+func legacyFolder(t *testing.T) string {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"snap1700000001.jpg":  "first",
+		"thumb1700000001.jpg": "first thumbnail",
+		"snap1700000002.jpg":  "second",
+		// Captured after an upgrade, it becomes the third frame
+		FileName(1):                 "third",
+		ThumbnailName(FileName(1)):  "third thumbnail",
+		XMPSidecarName(FileName(1)): "third sidecar",
+		EXCLUSIONS_FILE_NAME:        "snap1700000002.jpg\n",
+		"frames.jsonl":              `{"FileName":"snap1700000001.jpg"}` + "\n" + `{"FileName":"frame-000001.jpg"}` + "\n",
+	})
+	return dir
+}
+
+func checkMigrated(t *testing.T, dir string) {
+	t.Helper()
+	for name, want := range map[string]string{
+		FileName(1):                 "first",
+		ThumbnailName(FileName(1)):  "first thumbnail",
+		FileName(2):                 "second",
+		FileName(3):                 "third",
+		ThumbnailName(FileName(3)):  "third thumbnail",
+		XMPSidecarName(FileName(3)): "third sidecar",
+		EXCLUSIONS_FILE_NAME:        FileName(2) + "\n",
+		"frames.jsonl":              `{"FileName":"frame-000001.jpg"}` + "\n" + `{"FileName":"frame-000003.jpg"}` + "\n",
+	} {
+		if content := readFile(t, filepath.Join(dir, name)); content != want {
+			t.Errorf("%s is %q, want %q", name, content, want)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if name := entry.Name(); name == MIGRATION_JOURNAL_FILE_NAME || strings.HasPrefix(name, migrationTmpPrefix) {
+			t.Errorf("%s is left over", name)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := legacyFolder(t)
+	renames, err := Migrate(dir, true, "frames.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renames) != 3 {
+		t.Errorf("%d renames, want 3", len(renames))
+	}
+	if fileNames, _ := List(dir); !slices.Contains(fileNames, "snap1700000001.jpg") {
+		t.Fatal("the dry run renamed frames")
+	}
+
+	if _, err := Migrate(dir, false, "frames.jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	checkMigrated(t, dir)
+
+	// Nothing left to do
+	if renames, err := Migrate(dir, false, "frames.jsonl"); err != nil || len(renames) != 0 {
+		t.Errorf("second migration renamed %v: %v", renames, err)
+	}
+}
+
+func TestResumeMigration(t *testing.T) {
+	for stage := migrationStageTmpNames; stage <= migrationStageFiles; stage++ {
+		dir := legacyFolder(t)
+		renames, _ := plannedRenames(dir)
+		journal := &migrationJournal{Renames: renames, Moves: make(map[string]string), Stage: stage}
+		for oldName, newName := range renames {
+			journal.Moves[oldName] = newName
+		}
+		journal.Moves["thumb1700000001.jpg"] = ThumbnailName(FileName(1))
+		journal.Moves[ThumbnailName(FileName(1))] = ThumbnailName(FileName(3))
+		journal.Moves[XMPSidecarName(FileName(1))] = XMPSidecarName(FileName(3))
+
+		// Interrupted after a part of the stage was done
+		move := func(oldName, newName string) {
+			if err := os.Rename(filepath.Join(dir, oldName), filepath.Join(dir, newName)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		switch stage {
+		case migrationStageTmpNames:
+			move(FileName(1), migrationTmpPrefix+FileName(3))
+		case migrationStageFinalNames:
+			for oldName, newName := range journal.Moves {
+				move(oldName, migrationTmpPrefix+newName)
+			}
+			move(migrationTmpPrefix+FileName(3), FileName(3))
+			move(migrationTmpPrefix+FileName(1), FileName(1))
+		case migrationStageFiles:
+			for oldName, newName := range journal.Moves {
+				move(oldName, migrationTmpPrefix+newName)
+			}
+			for _, newName := range journal.Moves {
+				move(migrationTmpPrefix+newName, newName)
+			}
+			if err := renameInLog(filepath.Join(dir, "frames.jsonl"), renames); err != nil {
+				t.Fatal(err)
+			}
+			journal.Updated = []string{"frames.jsonl"}
+		}
+		if err := writeMigrationJournal(filepath.Join(dir, MIGRATION_JOURNAL_FILE_NAME), journal); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Migrate(dir, false, "frames.jsonl"); err != nil {
+			t.Fatal(err)
+		}
+		checkMigrated(t, dir)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build novips
+
+package thumbnail
+
+import "errors"
+
+// Built with the `novips` tag, so that libvips is not needed at all.
+const defaultBackend = BACKEND_GO
+
+func newVips() (Thumbnailer, error) {
+	return nil, errors.New(`built without libvips (the novips tag), use ThumbnailBackend = "go"`)
+}
+
+func Shutdown() {}
@@ -0,0 +1,97 @@
+// Package thumbnail scales frames down for the web UI.
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"slices"
+
+	"golang.org/x/image/draw"
+)
+
+// Names of the implementations, for the `ThumbnailBackend` config.
+const (
+	BACKEND_VIPS = "vips"
+	BACKEND_GO   = "go"
+)
+
+const JPEG_QUALITY = 80
+
+// Thumbnailer scales the JPEG at `path` to each of `widths`, keeping its
+// aspect ratio and never enlarging it, and returns the JPEG encoded images
+// in the same order.
+type Thumbnailer interface {
+	Thumbnails(path string, widths ...int) ([][]byte, error)
+}
+
+// The Thumbnailer of a `ThumbnailBackend`, libvips by default unless built
+// with the `novips` tag. libvips is only started when it is used.
+func New(backend string) (Thumbnailer, error) {
+	if len(backend) == 0 {
+		backend = defaultBackend
+	}
+	switch backend {
+	case BACKEND_GO:
+		return Go{}, nil
+	case BACKEND_VIPS:
+		return newVips()
+	default:
+		return nil, fmt.Errorf("unknown thumbnail backend %q", backend)
+	}
+}
+
+// Height of an image of size `width`x`height` scaled to `newWidth`.
+func ScaledHeight(width int, height int, newWidth int) int {
+	return max(1, int(math.Round(float64(height)*float64(newWidth)/float64(width))))
+}
+
+// Go is the Thumbnailer which does not need libvips, it is slower and uses
+// more memory as the whole frame is decoded.
+type Go struct{}
+
+func (Go) Thumbnails(path string, widths ...int) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src, err := jpeg.Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+
+	// Widest first, each image is scaled down from the previous one
+	order := make([]int, len(widths))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return widths[b] - widths[a] })
+
+	thumbnails := make([][]byte, len(widths))
+	for _, i := range order {
+		src = scale(src, widths[i])
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: JPEG_QUALITY}); err != nil {
+			return nil, err
+		}
+		thumbnails[i] = buf.Bytes()
+	}
+	return thumbnails, nil
+}
+
+func scale(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if width <= 0 || width >= bounds.Dx() {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, ScaledHeight(bounds.Dx(), bounds.Dy(), width)))
+	// Catmull-Rom widens its kernel when downscaling, so there is no aliasing
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,123 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writes a `width`x`height` JPEG, left half red and right half blue.
+func testFrame(t *testing.T, width int, height int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "frame.jpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestGoThumbnails(t *testing.T) {
+	path := testFrame(t, 600, 400)
+
+	// Not sorted, each is scaled down from the widest
+	widths := []int{150, 300, 60}
+	thumbnails, err := Go{}.Thumbnails(path, widths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbnails) != len(widths) {
+		t.Fatalf("%d thumbnails, want %d", len(thumbnails), len(widths))
+	}
+	for i, width := range widths {
+		img := decode(t, thumbnails[i])
+		size := img.Bounds().Size()
+		if want := image.Pt(width, width*2/3); size != want {
+			t.Errorf("thumbnail %d is %v, want %v", i, size, want)
+		}
+
+		// The halves are still where they were
+		r, _, b, _ := img.At(size.X/4, size.Y/2).RGBA()
+		if r < b {
+			t.Errorf("the left of thumbnail %d is not red", i)
+		}
+		r, _, b, _ = img.At(size.X*3/4, size.Y/2).RGBA()
+		if r > b {
+			t.Errorf("the right of thumbnail %d is not blue", i)
+		}
+	}
+}
+
+func TestGoThumbnailsNeverUpscale(t *testing.T) {
+	path := testFrame(t, 90, 60)
+	thumbnails, err := Go{}.Thumbnails(path, 90, 320, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, thumbnail := range thumbnails {
+		if size := decode(t, thumbnail).Bounds().Size(); size != image.Pt(90, 60) {
+			t.Errorf("thumbnail %d is %v, want the frame's size", i, size)
+		}
+	}
+}
+
+func TestScaledHeight(t *testing.T) {
+	for _, c := range []struct{ width, height, newWidth, want int }{
+		{6000, 4000, 300, 200},
+		{1920, 1080, 320, 180},
+		// Rounded, not truncated
+		{1000, 333, 100, 33},
+		{1000, 337, 100, 34},
+		// Never empty
+		{4000, 10, 100, 1},
+	} {
+		if got := ScaledHeight(c.width, c.height, c.newWidth); got != c.want {
+			t.Errorf("ScaledHeight(%d, %d, %d) = %d, want %d", c.width, c.height, c.newWidth, got, c.want)
+		}
+	}
+}
+
+func TestGoThumbnailsOfInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frame.jpg")
+	if err := os.WriteFile(path, []byte("not a jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Go{}).Thumbnails(path, 100); err == nil {
+		t.Error("no error for an invalid JPEG")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if thumbnailer, err := New(BACKEND_GO); err != nil || thumbnailer != (Go{}) {
+		t.Errorf("New(%q) = %v, %v, want the Go thumbnailer", BACKEND_GO, thumbnailer, err)
+	}
+	if _, err := New("imagemagick"); err == nil {
+		t.Error("no error for an unknown backend")
+	}
+}
@@ -0,0 +1,71 @@
+//go:build !novips
+
+package thumbnail
+
+import (
+	"sync"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Without the `novips` build tag, libvips is the default backend.
+const defaultBackend = BACKEND_VIPS
+
+// Thumbnails are scaled to their width, this only needs to be larger than
+// any height they can have.
+const maxThumbnailHeight = 10_000_000
+
+var (
+	vipsMu      sync.Mutex
+	vipsStarted bool
+)
+
+// Vips is the libvips Thumbnailer, JPEGs are shrunk while they are decoded
+// so this is much faster than the Go one.
+type Vips struct{}
+
+// libvips is started the first time it is needed.
+func newVips() (Thumbnailer, error) {
+	vipsMu.Lock()
+	defer vipsMu.Unlock()
+	if !vipsStarted {
+		vips.Startup(&vips.Config{
+			ConcurrencyLevel: 1,
+			MaxCacheMem:      8 * 1024 * 1024,
+			MaxCacheSize:     8 * 1024 * 1024,
+			MaxCacheFiles:    8,
+		})
+		vips.LoggingSettings(nil, vips.LogLevelCritical)
+		vipsStarted = true
+	}
+	return Vips{}, nil
+}
+
+func (Vips) Thumbnails(path string, widths ...int) ([][]byte, error) {
+	thumbnails := make([][]byte, len(widths))
+	for i, width := range widths {
+		image, err := vips.NewThumbnailWithSizeFromFile(path, width, maxThumbnailHeight, vips.InterestingNone, vips.SizeDown)
+		if err != nil {
+			return nil, err
+		}
+		buf, _, err := image.ExportJpeg(&vips.JpegExportParams{
+			Quality: JPEG_QUALITY,
+		})
+		image.Close()
+		if err != nil {
+			return nil, err
+		}
+		thumbnails[i] = buf
+	}
+	return thumbnails, nil
+}
+
+// Stops libvips if it was started.
+func Shutdown() {
+	vipsMu.Lock()
+	defer vipsMu.Unlock()
+	if vipsStarted {
+		vips.Shutdown()
+		vipsStarted = false
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -18,8 +19,11 @@ func CreateDirectoryIfNotExists(path string) error {
 	return nil
 }
 
+// Photo directories are named after the time the print started.
+const PHOTO_DIRECTORY_LAYOUT = "2006-01-02-15-04-05"
+
 func CreateNewPhotoDirectory(basePath string) string {
-	newDirPath := fmt.Sprintf("%s/%s", basePath, time.Now().Format("2006-01-02-15-04-05"))
+	newDirPath := fmt.Sprintf("%s/%s", basePath, time.Now().Format(PHOTO_DIRECTORY_LAYOUT))
 	// time.Now().Format("2006-01-02-15-04-05")
 	if _, err := os.Stat(newDirPath); os.IsNotExist(err) {
 		if err = os.MkdirAll(newDirPath, os.ModePerm); err != nil {
@@ -29,6 +33,13 @@ func CreateNewPhotoDirectory(basePath string) string {
 	return newDirPath
 }
 
+// When the print of a directory created by CreateNewPhotoDirectory started,
+// false for the other ones (e.g. orphans).
+func PhotoDirectoryTime(dirPath string) (time.Time, bool) {
+	t, err := time.ParseInLocation(PHOTO_DIRECTORY_LAYOUT, filepath.Base(dirPath), time.Local)
+	return t, err == nil
+}
+
 func Map[T, U any](ts []T, f func(T) U) []U {
 	us := make([]U, len(ts))
 	for i := range ts {
@@ -45,3 +56,27 @@ func SecondsToHumanDuration(seconds int) (int, int, int) {
 	remainingMinutes = remainingMinutes % 60
 	return days, hours, remainingMinutes
 }
+
+// Writes to a temporary file next to `path` first, so that readers (the web
+// UI, ffmpeg) never see a partially written picture.
+func WriteFileAtomically(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	// Temporary files are only readable by us
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
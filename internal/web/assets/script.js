@@ -11,3 +11,122 @@ function resetStream() {
 }
 window.addEventListener("focus", resetStream);
 window.addEventListener("touchend", resetStream);
+
+// Flipbook of the frames of a folder, see templates/scrubber.html
+function initScrubber(el) {
+  const img = el.querySelector(".scrubber-frame");
+  const link = el.querySelector(".scrubber-link");
+  const slider = el.querySelector(".scrubber-slider");
+  const label = el.querySelector(".scrubber-label");
+  const excludedBadge = el.querySelector(".scrubber-excluded");
+  const flagsBadge = el.querySelector(".scrubber-flags");
+  const playButton = el.querySelector(".scrubber-play");
+  const fpsInput = el.querySelector(".scrubber-fps");
+  const excludeInput = el.querySelector(".scrubber-exclude");
+
+  // ffmpeg frame rates may be rationals, e.g. 30000/1001
+  const [num, den] = el.dataset.fps.split("/");
+  fpsInput.value = Math.round(den ? num / den : num) || 24;
+
+  let frames = [];
+  let current = 0;
+  let timer = null;
+
+  function show(i) {
+    if (frames.length === 0) return;
+    current = (i + frames.length) % frames.length;
+    const frame = frames[current];
+    img.src = frame.PreviewURL;
+    link.href = frame.ImageURL;
+    slider.value = current;
+    label.textContent = `Frame ${frame.Number} of ${frames.length}`;
+    excludeInput.checked = frame.Excluded;
+    excludedBadge.classList.toggle("d-none", !frame.Excluded);
+    flagsBadge.textContent = (frame.Flags || []).join(", ");
+    flagsBadge.classList.toggle("d-none", !frame.Flags);
+    // So that playing does not wait for the next one
+    new Image().src = frames[(current + 1) % frames.length].PreviewURL;
+  }
+
+  // Playing skips the excluded frames, like the video
+  function next() {
+    for (let i = 1; i <= frames.length; i++) {
+      if (!frames[(current + i) % frames.length].Excluded) {
+        show(current + i);
+        return;
+      }
+    }
+  }
+
+  function play() {
+    if (timer) {
+      clearInterval(timer);
+      timer = null;
+      playButton.textContent = "Play";
+      return;
+    }
+    timer = setInterval(() => {
+      if (!el.isConnected) {
+        clearInterval(timer);
+        return;
+      }
+      next();
+    }, 1000 / Math.max(1, fpsInput.value));
+    playButton.textContent = "Pause";
+  }
+
+  function toggleExcluded(excluded) {
+    const frame = frames[current];
+    fetch(`/api/frames/${el.dataset.folder}/${frame.FileName}/excluded`, {
+      method: "POST",
+      body: new URLSearchParams({ excluded }),
+    }).then((res) => {
+      if (res.ok) frame.Excluded = excluded;
+      show(current);
+    });
+  }
+
+  slider.addEventListener("input", () => show(+slider.value));
+  playButton.addEventListener("click", play);
+  fpsInput.addEventListener("change", () => {
+    if (timer) {
+      play();
+      play();
+    }
+  });
+  excludeInput.addEventListener("change", () => toggleExcluded(excludeInput.checked));
+  el.addEventListener("keydown", (e) => {
+    if (e.target.tagName === "INPUT" && e.target.type !== "range") return;
+    if (e.key === "ArrowLeft") show(current - 1);
+    else if (e.key === "ArrowRight") show(current + 1);
+    else if (e.key === " ") play();
+    else if (e.key === "x" || e.key === "X") toggleExcluded(!frames[current].Excluded);
+    else return;
+    e.preventDefault();
+  });
+
+  fetch(`/api/frames/${el.dataset.folder}`)
+    .then((res) => res.json())
+    .then((list) => {
+      frames = list;
+      if (frames.length === 0) {
+        label.textContent = "No frames";
+        return;
+      }
+      slider.max = frames.length - 1;
+      show(0);
+      el.focus();
+    });
+}
+
+// htmx:load is fired for each element it adds to the page
+document.addEventListener("htmx:load", (e) => {
+  const scrubbers = [...e.target.querySelectorAll(".scrubber")];
+  if (e.target.matches(".scrubber")) scrubbers.push(e.target);
+  scrubbers
+    .filter((el) => !el.dataset.initialized)
+    .forEach((el) => {
+      el.dataset.initialized = true;
+      initScrubber(el);
+    });
+});
@@ -7,10 +7,16 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/pyrho/timelapse-serial/internal/manifest"
 )
 
 type printerInfo struct {
-	State string
+	State        string
+	TempNozzle   float32 `json:"temp_nozzle"`
+	TargetNozzle float32 `json:"target_nozzle"`
+	TempBed      float32 `json:"temp_bed"`
+	TargetBed    float32 `json:"target_bed"`
 }
 type jobInfo struct {
 	Id            int
@@ -24,71 +30,147 @@ type printInfo struct {
 	Printer printerInfo
 }
 
-type printInfoCache struct {
+// From `/api/v1/job`, only the file is used.
+type jobDetails struct {
+	File struct {
+		Name        string
+		DisplayName string `json:"display_name"`
+	}
+}
+
+// PrintInfoCache holds the last status fetched from PrusaLink.
+type PrintInfoCache struct {
 	info printInfo
-	mu   sync.RWMutex
+	// Whether the last fetch succeeded
+	valid bool
+	// The file being printed, fetched when the job changes
+	jobID   int
+	jobName string
+	mu      sync.RWMutex
 }
 
-func newPrintInfoCache() *printInfoCache {
-	return &printInfoCache{}
+func NewPrintInfoCache() *PrintInfoCache {
+	return &PrintInfoCache{}
 }
 
-func (pi *printInfoCache) get() printInfo {
+func (pi *PrintInfoCache) get() printInfo {
 	pi.mu.RLock()
 	defer pi.mu.RUnlock()
 	return pi.info
 }
 
-func (pi *printInfoCache) startLoop(printerUrl, apiKey string) {
+// The last known temperatures, nil when PrusaLink is not configured or has
+// not answered yet.
+func (pi *PrintInfoCache) Temperatures() *manifest.Temperatures {
+	if pi == nil {
+		return nil
+	}
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	if !pi.valid {
+		return nil
+	}
+	return &manifest.Temperatures{
+		Nozzle:       pi.info.Printer.TempNozzle,
+		TargetNozzle: pi.info.Printer.TargetNozzle,
+		Bed:          pi.info.Printer.TempBed,
+		TargetBed:    pi.info.Printer.TargetBed,
+	}
+}
+
+// The job being printed, nil when PrusaLink is not configured or the
+// printer is idle.
+func (pi *PrintInfoCache) Job() *manifest.Job {
+	if pi == nil {
+		return nil
+	}
+	pi.mu.RLock()
+	defer pi.mu.RUnlock()
+	if !pi.valid || pi.info.Job.Id == 0 {
+		return nil
+	}
+	job := &manifest.Job{ID: pi.info.Job.Id, Progress: pi.info.Job.Progress}
+	if pi.jobID == pi.info.Job.Id {
+		job.Name = pi.jobName
+	}
+	return job
+}
+
+func (pi *PrintInfoCache) StartLoop(printerUrl, apiKey string) {
 	ticker := time.NewTicker(10 * time.Second)
 	// We never want to stop!
 	// defer ticker.Stop()
 
 	go func() {
-		info, _ := getPrinterInformation(printerUrl, apiKey)
-		pi.mu.Lock()
-		pi.info = info
-		pi.mu.Unlock()
+		info, err := getPrinterInformation(printerUrl, apiKey)
+		pi.update(info, err, printerUrl, apiKey)
 
 		for range ticker.C {
 			info, err := getPrinterInformation(printerUrl, apiKey)
 			if err != nil {
 				log.Printf("Cannot get printer info: %v\n", err)
 			}
-			pi.mu.Lock()
-			pi.info = info
-			pi.mu.Unlock()
+			pi.update(info, err, printerUrl, apiKey)
 		}
 	}()
 }
 
+func (pi *PrintInfoCache) update(info printInfo, err error, printerUrl, apiKey string) {
+	pi.mu.RLock()
+	jobChanged := err == nil && info.Job.Id != 0 && info.Job.Id != pi.jobID
+	pi.mu.RUnlock()
+
+	var jobName string
+	if jobChanged {
+		var job jobDetails
+		if jobErr := getPrusaLink(printerUrl+"/api/v1/job", apiKey, &job); jobErr != nil {
+			log.Printf("Cannot get job info: %v\n", jobErr)
+			jobChanged = false
+		} else if jobName = job.File.DisplayName; len(jobName) == 0 {
+			jobName = job.File.Name
+		}
+	}
+
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	pi.info = info
+	pi.valid = err == nil
+	if jobChanged {
+		pi.jobID = info.Job.Id
+		pi.jobName = jobName
+	}
+}
+
 func getPrinterInformation(printeUrl string, apiKey string) (printInfo, error) {
+	var printInf printInfo
+	if err := getPrusaLink(printeUrl+"/api/v1/status", apiKey, &printInf); err != nil {
+		return printInfo{}, err
+	}
+	return printInf, nil
+}
+
+func getPrusaLink(url string, apiKey string, v interface{}) error {
 	// Create HTTP client
 	client := http.Client{}
 
-	req, err := http.NewRequest("GET", printeUrl+"/api/v1/status", nil)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return printInfo{}, err
+		return err
 	}
 	req.Header.Set("X-Api-Key", apiKey)
 
 	// Send request and get response
 	resp, err := client.Do(req)
 	if err != nil {
-		return printInfo{}, err
+		return err
 	}
 	defer resp.Body.Close()
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return printInfo{}, err
+		return err
 	}
 
-	var printInf printInfo
-	if err = json.Unmarshal(b, &printInf); err != nil {
-		return printInfo{}, err
-	}
-	return printInf, nil
-
+	return json.Unmarshal(b, v)
 }
 
 /*
@@ -1,10 +1,11 @@
 package web
 
 import (
-	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"maps"
 
 	// For debugging
 	// _ "net/http/pprof"
@@ -14,13 +15,17 @@ import (
 	"regexp"
 	"slices"
 	"strconv"
-	"sync"
 	"time"
 
 	"log"
 	"net/http"
 
+	"github.com/pyrho/timelapse-serial/internal/camera"
+	"github.com/pyrho/timelapse-serial/internal/capture"
 	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/gcode"
+	"github.com/pyrho/timelapse-serial/internal/manifest"
+	"github.com/pyrho/timelapse-serial/internal/snaps"
 	"github.com/pyrho/timelapse-serial/internal/utils"
 	"github.com/pyrho/timelapse-serial/internal/web/assets"
 	"github.com/pyrho/timelapse-serial/internal/web/vendor"
@@ -28,6 +33,8 @@ import (
 
 const FOLDERS_PER_PAGE = 5
 
+const THUMBNAILS_PER_PAGE = 60
+
 func getTimelapseFolderSubSlice(allFolders []TLInfo, n int) []TLInfo {
 	// 0 => 0..4
 	// 1 => 5..9
@@ -46,69 +53,148 @@ func getTimelapseFolderSubSlice(allFolders []TLInfo, n int) []TLInfo {
 
 }
 
-func getSnapshotsThumbnails(folderName string, outputDir string, maxRoutines int, ctx context.Context) []Hi {
-	log.Println("Creating all thumbnails")
-	mu := sync.Mutex{}
-	var allThumbs []Hi
-	snaps := getSnapsForTimelapseFolder(outputDir, folderName)
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, maxRoutines)
-	nbSnaps := len(snaps)
-	for ix, snap := range snaps {
-		wg.Add(1)
-		sem <- struct{}{} // Acquire semaphore
-		go func(sn SnapInfo, index int) {
-			defer wg.Done()
-			imgPath := filepath.Join(outputDir, sn.FolderName, sn.FileName)
-			thumbPath := CreateAndSaveThumbnail(imgPath, ctx)
-			thumbRelativePath, _ := filepath.Rel(outputDir, thumbPath)
-
-			mu.Lock()
-			allThumbs = append(allThumbs, Hi{
-				ThumbnailPath: thumbRelativePath,
-				ix:            index,
-				ImgPath:       sn.FolderName + "/" + sn.FileName,
-			})
-			mu.Unlock()
+// One page of the snaps grid, newest frames first. Thumbnails which are not
+// in the cache yet are served by `/thumbnail/`, which generates them, so
+// this does not wait for them.
+func getSnapshotsPage(thumbnails *ThumbnailCache, outputDir string, folderName string, page int) (map[string]interface{}, error) {
+	snapInfos, err := getSnapsForTimelapseFolder(outputDir, folderName)
+	if err != nil {
+		return nil, err
+	}
+	excluded, _ := snaps.Excluded(filepath.Join(outputDir, folderName))
+	flags := getFrameFlags(outputDir, folderName)
+	total := len(snapInfos)
+	start := min(max(page, 0)*THUMBNAILS_PER_PAGE, total)
+	end := min(start+THUMBNAILS_PER_PAGE, total)
+
+	var thumbs []Hi
+	for ix := total - 1 - start; ix >= total-end; ix-- {
+		hi := makeHi(thumbnails, folderName, snapInfos[ix].FileName, ix)
+		hi.Excluded = excluded[snapInfos[ix].FileName]
+		hi.Flags = flags[snapInfos[ix].FileName]
+		thumbs = append(thumbs, hi)
+	}
+	return map[string]interface{}{
+		"FolderName":  folderName,
+		"Thumbs":      thumbs,
+		"Total":       total,
+		"HasNextPage": end < total,
+		"NextPage":    page + 1,
+		// Frame numbers, counting from the first one
+		"NextFirst": total - end,
+		"NextLast":  max(1, total-end-THUMBNAILS_PER_PAGE+1),
+	}, nil
+}
 
-			log.Printf("Thumbnail [%d/%d] created and added to slice", index, nbSnaps)
-			<-sem
-		}(snap, ix)
+// Serves the thumbnail or preview of a frame, generating it when missing.
+func serveCachedFrame(w http.ResponseWriter, r *http.Request, thumbnails *ThumbnailCache, file func(cachedFrame) string) {
+	folderName, fileName := r.PathValue("folderName"), r.PathValue("fileName")
+	if !snaps.IsFrame(fileName) || !isFolderName(folderName) {
+		http.NotFound(w, r)
+		return
+	}
+	frame, err := thumbnails.getOrGenerate(folderName, fileName)
+	if err != nil {
+		log.Println("Cannot create thumbnail for", fileName, err)
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(thumbnails.dir, folderName, file(frame)))
+}
 
+// Every frame of a folder in capture order, for the scrubber.
+func getFrameList(thumbnails *ThumbnailCache, outputDir string, folderName string) ([]FrameInfo, error) {
+	snapshotsDir := filepath.Join(outputDir, folderName)
+	fileNames, err := snaps.List(snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := snaps.Excluded(snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	flags := getFrameFlags(outputDir, folderName)
+	frameInfos := make([]FrameInfo, len(fileNames))
+	for i, fileName := range fileNames {
+		frameInfos[i] = FrameInfo{
+			Number:     i + 1,
+			FileName:   fileName,
+			PreviewURL: "/preview/" + folderName + "/" + fileName,
+			ImageURL:   "/serve/" + folderName + "/" + fileName,
+			Excluded:   excluded[fileName],
+			Flags:      flags[fileName],
+		}
+		if frame, found := thumbnails.get(folderName, fileName); found {
+			frameInfos[i].PreviewURL = "/thumbnails/" + folderName + "/" + frame.Preview
+		}
 	}
+	return frameInfos, nil
+}
 
-	// Wait for all goroutines to finish
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		log.Println("Done waiting!")
-		close(done)
-	}()
-
-	select {
-	case <-done:
-		log.Println("All thumbnails created!")
-		slices.SortFunc(allThumbs, func(a, b Hi) int {
-			return b.ix - a.ix
-		})
-		return allThumbs
-	case <-ctx.Done():
-		log.Println("Thumbnail creation aborted")
-		return []Hi{}
+// Why the frames of a folder look wrong, by file name, see the frame
+// analysis.
+func getFrameFlags(outputDir string, folderName string) map[string][]string {
+	flags := make(map[string][]string)
+	frames, err := manifest.Read(filepath.Join(outputDir, folderName))
+	if err != nil {
+		log.Println("Cannot read the frame manifest of", folderName, err)
+		return flags
 	}
+	for _, m := range frames {
+		if m.Quality != nil && len(m.Quality.Flags) > 0 {
+			flags[m.FileName] = m.Quality.Flags
+		}
+	}
+	return flags
+}
 
+func makeHi(thumbnails *ThumbnailCache, folderName string, fileName string, ix int) Hi {
+	thumbnailURL := "/thumbnail/" + folderName + "/" + fileName
+	if frame, found := thumbnails.get(folderName, fileName); found {
+		thumbnailURL = "/thumbnails/" + folderName + "/" + frame.Thumbnail
+	}
+	return Hi{
+		ThumbnailURL: thumbnailURL,
+		Number:       ix + 1,
+		ImgPath:      folderName + "/" + fileName,
+	}
 }
 
-func StartWebServer(conf *config.Config) {
+// The thumbnails of the folder's first page are generated by the browser's
+// requests, the other ones are generated in the background.
+func snapsTemplateData(thumbnails *ThumbnailCache, outputDir string, folderName string) (map[string]interface{}, error) {
+	data, err := getSnapshotsPage(thumbnails, outputDir, folderName, 0)
+	if err != nil {
+		return nil, err
+	}
+	snapInfos, err := getSnapsForTimelapseFolder(outputDir, folderName)
+	if err != nil {
+		return nil, err
+	}
+	fileNames := utils.Map(snapInfos, func(s SnapInfo) string { return s.FileName })
+	slices.Reverse(fileNames)
+	thumbnails.warm(folderName, fileNames)
+
+	timelapseVideoPath := fmt.Sprintf("%s/%s/output.mp4", outputDir, folderName)
+	hasTimelapseVideo := true
+	if _, err := os.Stat(timelapseVideoPath); errors.Is(err, os.ErrNotExist) {
+		hasTimelapseVideo = false
+	}
+	data["HasTimelapse"] = hasTimelapseVideo
+	data["Captures"] = camera.GetCaptureStats(filepath.Join(outputDir, folderName))
+	return data, nil
+}
 
-	printerInfoEnabled := len(conf.Web.PrinterUrl) > 0
-    log.Println(printerInfoEnabled )
-	var printerInfoCache *printInfoCache
+// A folder of the output directory, and not a path out of it.
+func isFolderName(name string) bool {
+	return filepath.Base(name) == name && name != "." && name != ".."
+}
 
-	if printerInfoEnabled {
-		printerInfoCache = newPrintInfoCache()
-		printerInfoCache.startLoop(conf.Web.PrinterUrl, conf.Web.PrusaLinkKey)
-	}
+// `printerInfoCache` is nil when PrusaLink is not configured.
+func StartWebServer(conf *config.Config, cam camera.CameraWrapperInterface, captureWorker *capture.Worker, printerInfoCache *PrintInfoCache, thumbnails *ThumbnailCache) {
+
+	printerInfoEnabled := printerInfoCache != nil
+    log.Println(printerInfoEnabled )
 
 	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFileFS(w, r, assets.All, "favicon.ico")
@@ -117,6 +203,7 @@ func StartWebServer(conf *config.Config) {
 	http.Handle("/vendor/", http.StripPrefix("/vendor/", http.FileServerFS(vendor.All)))
 
 	http.Handle("/serve/", http.StripPrefix("/serve/", http.FileServer(http.Dir(conf.Camera.OutputDir))))
+	http.Handle("/thumbnails/", http.StripPrefix("/thumbnails/", http.FileServer(http.Dir(thumbnails.dir))))
 
 	http.HandleFunc("/get-printer-status5", func(w http.ResponseWriter, r *http.Request) {
         if !printerInfoEnabled {
@@ -145,27 +232,189 @@ func StartWebServer(conf *config.Config) {
 
 	http.HandleFunc("/clicked/{folderName}", func(w http.ResponseWriter, r *http.Request) {
 		folderName := r.PathValue("folderName")
-		timelapseVideoPath := fmt.Sprintf("%s/%s/output.mp4", conf.Camera.OutputDir, folderName)
-		hasTimelapseVideo := true
-		if _, err := os.Stat(timelapseVideoPath); errors.Is(err, os.ErrNotExist) {
-			hasTimelapseVideo = false
+		if !isFolderName(folderName) {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := snapsTemplateData(thumbnails, conf.Camera.OutputDir, folderName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
 		}
-		ctx, cancel := context.WithCancel(r.Context())
-		defer cancel()
 		template := template.Must(template.ParseFS(Templates, "templates/snaps.html"))
-		if err := template.ExecuteTemplate(w, "snaps", map[string]interface{}{
-			"AllThumbs":    getSnapshotsThumbnails(folderName, conf.Camera.OutputDir, conf.Web.ThumbnailCreationMaxGoroutines, ctx),
-			"FolderName":   folderName,
-			"HasTimelapse": hasTimelapseVideo,
-		}); err != nil {
+		if err := template.ExecuteTemplate(w, "snaps", data); err != nil {
 			log.Printf("Cannot execute template snaps, %s\n", err)
 		}
 	})
 
+	http.HandleFunc("/snaps/{folderName}/{page}", func(w http.ResponseWriter, r *http.Request) {
+		folderName := r.PathValue("folderName")
+		if !isFolderName(folderName) {
+			http.NotFound(w, r)
+			return
+		}
+		page, _ := strconv.Atoi(r.PathValue("page"))
+		data, err := getSnapshotsPage(thumbnails, conf.Camera.OutputDir, folderName, page)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		template := template.Must(template.ParseFS(Templates, "templates/snaps.html"))
+		if err := template.ExecuteTemplate(w, "snaps_page", data); err != nil {
+			log.Printf("Cannot execute template snaps_page, %s\n", err)
+		}
+	})
+
+	http.HandleFunc("/thumbnail/{folderName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
+		serveCachedFrame(w, r, thumbnails, func(frame cachedFrame) string { return frame.Thumbnail })
+	})
+
+	http.HandleFunc("/preview/{folderName}/{fileName}", func(w http.ResponseWriter, r *http.Request) {
+		serveCachedFrame(w, r, thumbnails, func(frame cachedFrame) string { return frame.Preview })
+	})
+
+	http.HandleFunc("/scrubber/{folderName}", func(w http.ResponseWriter, r *http.Request) {
+		if !isFolderName(r.PathValue("folderName")) {
+			http.NotFound(w, r)
+			return
+		}
+		template := template.Must(template.ParseFS(Templates, "templates/scrubber.html"))
+		if err := template.ExecuteTemplate(w, "scrubber", map[string]interface{}{
+			"FolderName":      r.PathValue("folderName"),
+			"FramesPerSecond": conf.FFMPEG.WithDefaults().FramesPerSecond,
+		}); err != nil {
+			log.Printf("Cannot execute template scrubber, %s\n", err)
+		}
+	})
+
+	http.HandleFunc("/api/frames/{folderName}", func(w http.ResponseWriter, r *http.Request) {
+		folderName := r.PathValue("folderName")
+		if !isFolderName(folderName) {
+			http.NotFound(w, r)
+			return
+		}
+		frames, err := getFrameList(thumbnails, conf.Camera.OutputDir, folderName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(frames); err != nil {
+			log.Printf("Cannot encode frames of %s, %s\n", folderName, err)
+		}
+	})
+
+	// `excluded` is `true` or `false`
+	http.HandleFunc("POST /api/frames/{folderName}/{fileName}/excluded", func(w http.ResponseWriter, r *http.Request) {
+		folderName, fileName := r.PathValue("folderName"), r.PathValue("fileName")
+		if !isFolderName(folderName) {
+			http.NotFound(w, r)
+			return
+		}
+		excluded, err := strconv.ParseBool(r.FormValue("excluded"))
+		if err != nil {
+			http.Error(w, "excluded must be true or false", http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(conf.Camera.OutputDir, folderName, fileName)); err != nil || !snaps.IsFrame(fileName) {
+			http.NotFound(w, r)
+			return
+		}
+		if err := snaps.SetExcluded(filepath.Join(conf.Camera.OutputDir, folderName), fileName, excluded); err != nil {
+			log.Printf("Cannot exclude %s, %s\n", fileName, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	http.HandleFunc("/camera/status", func(w http.ResponseWriter, r *http.Request) {
+		template := template.Must(template.ParseFS(Templates, "templates/camera_status.html"))
+		if err := template.ExecuteTemplate(w, "camera_status", cameraStatusTemplateData(cam, captureWorker)); err != nil {
+			log.Printf("Cannot execute template camera_status, %s\n", err)
+		}
+	})
+
+	http.HandleFunc("/api/camera/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cam.GetStatus()); err != nil {
+			log.Printf("Cannot encode camera status, %s\n", err)
+		}
+	})
+
+	http.HandleFunc("/camera/settings", func(w http.ResponseWriter, r *http.Request) {
+		settings, err := cam.GetSettings()
+		templateData := map[string]interface{}{
+			"Settings": utils.Map(settings, func(s camera.Setting) map[string]interface{} {
+				return settingTemplateData(s, nil)
+			}),
+		}
+		if err != nil {
+			templateData["Error"] = fmt.Sprintf("Cannot read camera settings: %s", err)
+		}
+		template := template.Must(template.ParseFS(Templates, "templates/camera_settings.html"))
+		if err := template.ExecuteTemplate(w, "camera_settings", templateData); err != nil {
+			log.Printf("Cannot execute template camera_settings, %s\n", err)
+		}
+	})
+
+	http.HandleFunc("POST /camera/settings/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		setErr := cam.SetSetting(name, r.FormValue("value"))
+		if setErr != nil {
+			log.Printf("Cannot set camera setting %s: %s\n", name, setErr)
+		}
+
+		settings, err := cam.GetSettings()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		ix := slices.IndexFunc(settings, func(s camera.Setting) bool { return s.Name == name })
+		if ix < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		template := template.Must(template.ParseFS(Templates, "templates/camera_settings.html"))
+		if err := template.ExecuteTemplate(w, "camera_setting", settingTemplateData(settings[ix], setErr)); err != nil {
+			log.Printf("Cannot execute template camera_setting, %s\n", err)
+		}
+	})
+
+	http.HandleFunc("POST /gcode/analyze", func(w http.ResponseWriter, r *http.Request) {
+		var templateData map[string]interface{}
+		file, _, err := r.FormFile("gcode")
+		if err != nil {
+			templateData = map[string]interface{}{"Error": fmt.Sprintf("Cannot read the uploaded file: %s", err)}
+		} else {
+			defer file.Close()
+			analysis, err := gcode.Analyze(file)
+			if err != nil {
+				templateData = map[string]interface{}{"Error": fmt.Sprintf("Cannot analyze G-code: %s", err)}
+			} else {
+				templateData = forecastTemplateData(gcode.MakeForecast(analysis, conf.Camera.OutputDir, &conf.FFMPEG))
+			}
+		}
+		template := template.Must(template.ParseFS(Templates, "templates/gcode_analysis.html"))
+		if err := template.ExecuteTemplate(w, "gcode_analysis", templateData); err != nil {
+			log.Printf("Cannot execute template gcode_analysis, %s\n", err)
+		}
+	})
+
 	http.HandleFunc("/modal/{folder}/{file}", func(w http.ResponseWriter, r *http.Request) {
+		if !isFolderName(r.PathValue("folder")) {
+			http.NotFound(w, r)
+			return
+		}
+		excluded, _ := snaps.Excluded(filepath.Join(conf.Camera.OutputDir, r.PathValue("folder")))
 		template := template.Must(template.ParseFS(Templates, "templates/modal.html"))
 		if err := template.ExecuteTemplate(w, "modal", map[string]interface{}{
-			"ImgPath": r.PathValue("folder") + "/" + r.PathValue("file"),
+			"FolderName":  r.PathValue("folder"),
+			"FileName":    r.PathValue("file"),
+			"ImgPath":     r.PathValue("folder") + "/" + r.PathValue("file"),
+			"PreviewPath": thumbnails.previewPath(r.PathValue("folder"), r.PathValue("file")),
+			"Excluded":    excluded[r.PathValue("file")],
+			"Flags":       getFrameFlags(conf.Camera.OutputDir, r.PathValue("folder"))[r.PathValue("file")],
 		}); err != nil {
 			log.Printf("Cannot execute template modal, %s\n", err)
 		}
@@ -187,22 +436,21 @@ func StartWebServer(conf *config.Config) {
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		timelapseFolders := getTimelapseFolders(conf.Camera.OutputDir)
-		var firstTimelapseFolderName string
-		if len(timelapseFolders) > 0 {
-			firstTimelapseFolderName = timelapseFolders[0].FolderName
-		}
-		timelapseVideoPath := fmt.Sprintf("%s/%s/output.mp4", conf.Camera.OutputDir, firstTimelapseFolderName)
-		hasTimelapseVideo := true
-		if _, err := os.Stat(timelapseVideoPath); errors.Is(err, os.ErrNotExist) {
-			hasTimelapseVideo = false
-		}
 		templateData := map[string]interface{}{
 			"Timelapses":   getTimelapseFolderSubSlice(timelapseFolders, 0),
-			"HasTimelapse": hasTimelapseVideo,
-			"FolderName":   firstTimelapseFolderName,
 			"LiveFeedURL":  conf.Camera.LiveFeedURL,
+			"CameraStatus": cameraStatusTemplateData(cam, captureWorker),
 			"Pages":        make([]int, (len(timelapseFolders)/5)+1),
 		}
+		// The newest print is shown, there is none before the first one
+		if len(timelapseFolders) > 0 {
+			folderName := timelapseFolders[0].FolderName
+			if snapsData, err := snapsTemplateData(thumbnails, conf.Camera.OutputDir, folderName); err != nil {
+				log.Printf("Cannot read the snaps of %s, %s\n", folderName, err)
+			} else {
+				maps.Copy(templateData, snapsData)
+			}
+		}
 
 		if printerInfoEnabled {
 			state := printerInfoCache.get()
@@ -223,7 +471,7 @@ func StartWebServer(conf *config.Config) {
 		template := template.Must(
 			template.ParseFS(
 				Templates,
-				"templates/layout.html", "templates/title.html", "templates/folders.html", "templates/snaps.html", "templates/folder_nav.html"),
+				"templates/layout.html", "templates/title.html", "templates/folders.html", "templates/snaps.html", "templates/folder_nav.html", "templates/camera_status.html"),
 		)
 		if err := template.Execute(w, templateData); err != nil {
 			log.Printf("Cannot execute templates for main page, %s\n", err)
@@ -234,23 +482,77 @@ func StartWebServer(conf *config.Config) {
 
 }
 
-func getSnapsForTimelapseFolder(outputDir string, folderName string) []SnapInfo {
-	validSnap := regexp.MustCompile(`^snap[0-9]+.jpg$`)
+func cameraStatusTemplateData(cam camera.CameraWrapperInterface, captureWorker *capture.Worker) map[string]interface{} {
+	status := cam.GetStatus()
+	return map[string]interface{}{
+		"State":    string(status.State),
+		"Device":   status.Device.String(),
+		"Error":    status.Error,
+		"Overruns": captureWorker.Overruns(),
+	}
+}
+
+func settingTemplateData(s camera.Setting, err error) map[string]interface{} {
+	data := map[string]interface{}{
+		"Group":    s.Group,
+		"Name":     s.Name,
+		"Label":    s.Label,
+		"Value":    s.Value,
+		"Options":  s.Options,
+		"ReadOnly": s.ReadOnly,
+		"Error":    "",
+	}
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	return data
+}
+
+func forecastTemplateData(f gcode.Forecast) map[string]interface{} {
+	printTime := "unknown"
+	if f.EstimatedPrintTime > 0 {
+		printTime = f.EstimatedPrintTime.String()
+	}
+	freeSpace := ""
+	if f.FreeSpace > 0 {
+		freeSpace = gcode.FormatBytes(f.FreeSpace)
+	}
+	return map[string]interface{}{
+		"Layers":            f.Layers,
+		"CaptureMarkers":    f.CaptureMarkers,
+		"PrintTime":         printTime,
+		"Frames":            f.Frames,
+		"DiskUsage":         gcode.FormatBytes(f.DiskUsage),
+		"FrameSize":         gcode.FormatBytes(f.FrameSize),
+		"FrameSizeMeasured": f.FrameSizeMeasured,
+		"FreeSpace":         freeSpace,
+		"FitsOnDisk":        f.FitsOnDisk(),
+		"Videos": utils.Map(f.Videos, func(v gcode.VideoForecast) map[string]interface{} {
+			return map[string]interface{}{
+				"Profile":         v.Profile,
+				"FramesPerSecond": v.FramesPerSecond,
+				"Duration":        v.Duration.Round(100 * time.Millisecond).String(),
+			}
+		}),
+		"Error": "",
+	}
+}
+
+// Both the numbered and the legacy (timestamped) frames, in capture order.
+func getSnapsForTimelapseFolder(outputDir string, folderName string) ([]SnapInfo, error) {
 	var tl []SnapInfo
-	files, err := os.ReadDir(filepath.Join(outputDir, folderName))
+	fileNames, err := snaps.List(filepath.Join(outputDir, folderName))
 	if err != nil {
-		log.Fatalf("1: Cannot read output dir: %s", err)
+		return nil, err
 	}
-	for _, file := range files {
-		if !file.IsDir() && validSnap.MatchString(file.Name()) {
-			tl = append(tl, SnapInfo{
-				FilePath:   filepath.Join(outputDir, file.Name()),
-				FolderName: folderName,
-				FileName:   file.Name(),
-			})
-		}
+	for _, fileName := range fileNames {
+		tl = append(tl, SnapInfo{
+			FilePath:   filepath.Join(outputDir, fileName),
+			FolderName: folderName,
+			FileName:   fileName,
+		})
 	}
-	return tl
+	return tl, nil
 }
 
 func countFiles(dirPath string) uint {
@@ -258,9 +560,8 @@ func countFiles(dirPath string) uint {
 
 	entries, _ := os.ReadDir(dirPath)
 
-	validSnap := regexp.MustCompile(`^snap[0-9]+.jpg$`)
 	for _, entry := range entries {
-		if !entry.IsDir() && validSnap.MatchString(entry.Name()) {
+		if !entry.IsDir() && snaps.IsFrame(entry.Name()) {
 			fileCount++
 		}
 	}
@@ -295,6 +596,7 @@ func getTimelapseFolders(outputDir string) []TLInfo {
 				FolderName:        file.Name(),
 				NumberOfSnaps:     countFiles(filepath.Join(outputDir, file.Name())),
 				HasTimelapseVideo: hasTimelapseVideo(filepath.Join(outputDir, file.Name())),
+				Captures:          camera.GetCaptureStats(filepath.Join(outputDir, file.Name())),
 			})
 		}
 	}
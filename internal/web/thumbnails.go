@@ -0,0 +1,273 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pyrho/timelapse-serial/internal/capture"
+	"github.com/pyrho/timelapse-serial/internal/config"
+	"github.com/pyrho/timelapse-serial/internal/snaps"
+	"github.com/pyrho/timelapse-serial/internal/thumbnail"
+	"github.com/pyrho/timelapse-serial/internal/utils"
+)
+
+// Name of the file, in each folder of the cache, listing the frames whose
+// thumbnail and preview were generated.
+const THUMBNAIL_INDEX_FILE_NAME = "index.jsonl"
+
+const (
+	DEFAULT_THUMBNAIL_WIDTH = 360
+	DEFAULT_PREVIEW_WIDTH   = 1280
+)
+
+type cachedFrame struct {
+	FileName string
+	// Relative to the folder in the cache
+	Thumbnail string
+	Preview   string
+	// The widths they were made for, they are made again when the config
+	// changes
+	ThumbnailWidth int
+	PreviewWidth   int
+}
+
+// ThumbnailCache holds the thumbnails (for the grid) and previews (for the
+// modal) of the frames, in a directory mirroring the print folders.
+// They are generated right after each capture, and when a folder is opened
+// only for the frames which have none yet.
+type ThumbnailCache struct {
+	outputDir      string
+	dir            string
+	thumbnailer    thumbnail.Thumbnailer
+	thumbnailWidth int
+	previewWidth   int
+	// Bounds how many frames are scaled at the same time for the web UI
+	sem chan struct{}
+	// Guards the index files and the fields below
+	mu sync.Mutex
+	// The index of each folder, read when first needed
+	indexes map[string]map[string]cachedFrame
+	// Folders whose missing thumbnails are being generated
+	warming map[string]bool
+}
+
+func NewThumbnailCache(conf *config.Config) *ThumbnailCache {
+	dir := conf.Web.ThumbnailCacheDir
+	if len(dir) == 0 {
+		dir = filepath.Join(conf.Camera.OutputDir, ".thumbnails")
+	}
+	thumbnailWidth := conf.Web.ThumbnailWidth
+	if thumbnailWidth <= 0 {
+		thumbnailWidth = DEFAULT_THUMBNAIL_WIDTH
+	}
+	previewWidth := conf.Web.PreviewWidth
+	if previewWidth <= 0 {
+		previewWidth = DEFAULT_PREVIEW_WIDTH
+	}
+	thumbnailer, err := thumbnail.New(conf.Web.ThumbnailBackend)
+	if err != nil {
+		log.Fatalln("Cannot create thumbnails:", err)
+	}
+	maxGoroutines := conf.Web.ThumbnailCreationMaxGoroutines
+	if maxGoroutines <= 0 {
+		maxGoroutines = runtime.NumCPU()
+	}
+	return &ThumbnailCache{
+		outputDir:      conf.Camera.OutputDir,
+		dir:            dir,
+		thumbnailer:    thumbnailer,
+		thumbnailWidth: thumbnailWidth,
+		previewWidth:   previewWidth,
+		sem:            make(chan struct{}, maxGoroutines),
+		indexes:        make(map[string]map[string]cachedFrame),
+		warming:        make(map[string]bool),
+	}
+}
+
+// The frames of a folder which are in the cache, by file name. `c.mu` must
+// be held.
+func (c *ThumbnailCache) index(folderName string) map[string]cachedFrame {
+	if frames, found := c.indexes[folderName]; found {
+		return frames
+	}
+	frames := make(map[string]cachedFrame)
+	c.indexes[folderName] = frames
+	f, err := os.Open(filepath.Join(c.dir, folderName, THUMBNAIL_INDEX_FILE_NAME))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Println("Cannot read thumbnail index", err)
+		}
+		return frames
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var frame cachedFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err == nil {
+			frames[frame.FileName] = frame
+		}
+	}
+	return frames
+}
+
+// Looks up a frame in the cache, making sure its files were not removed.
+func (c *ThumbnailCache) get(folderName string, fileName string) (cachedFrame, bool) {
+	c.mu.Lock()
+	frame, found := c.index(folderName)[fileName]
+	c.mu.Unlock()
+	if !found || frame.ThumbnailWidth != c.thumbnailWidth || frame.PreviewWidth != c.previewWidth {
+		return cachedFrame{}, false
+	}
+	for _, name := range []string{frame.Thumbnail, frame.Preview} {
+		if _, err := os.Stat(filepath.Join(c.dir, folderName, name)); err != nil {
+			return cachedFrame{}, false
+		}
+	}
+	return frame, true
+}
+
+// Creates the thumbnail and preview of a frame, and adds them to the index.
+func (c *ThumbnailCache) generate(folderName string, fileName string) (cachedFrame, error) {
+	cacheFolder := filepath.Join(c.dir, folderName)
+	if err := os.MkdirAll(cacheFolder, os.ModePerm); err != nil {
+		return cachedFrame{}, err
+	}
+	thumbnailName := snaps.ThumbnailName(fileName)
+	frame := cachedFrame{
+		FileName:       fileName,
+		Thumbnail:      thumbnailName,
+		Preview:        "preview" + strings.TrimPrefix(thumbnailName, "thumb"),
+		ThumbnailWidth: c.thumbnailWidth,
+		PreviewWidth:   c.previewWidth,
+	}
+
+	images, err := c.thumbnailer.Thumbnails(
+		filepath.Join(c.outputDir, folderName, fileName),
+		c.thumbnailWidth,
+		c.previewWidth,
+	)
+	if err != nil {
+		return cachedFrame{}, err
+	}
+	// The web server may be serving them at the same time
+	if err := utils.WriteFileAtomically(filepath.Join(cacheFolder, frame.Thumbnail), images[0]); err != nil {
+		return cachedFrame{}, err
+	}
+	if err := utils.WriteFileAtomically(filepath.Join(cacheFolder, frame.Preview), images[1]); err != nil {
+		return cachedFrame{}, err
+	}
+
+	return frame, c.addToIndex(folderName, frame)
+}
+
+func (c *ThumbnailCache) addToIndex(folderName string, frame cachedFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, err := os.OpenFile(
+		filepath.Join(c.dir, folderName, THUMBNAIL_INDEX_FILE_NAME),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.index(folderName)[frame.FileName] = frame
+	return nil
+}
+
+// The cached frame, generated when missing.
+func (c *ThumbnailCache) getOrGenerate(folderName string, fileName string) (cachedFrame, error) {
+	if frame, found := c.get(folderName, fileName); found {
+		return frame, nil
+	}
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	// It may have been generated while waiting
+	if frame, found := c.get(folderName, fileName); found {
+		return frame, nil
+	}
+	return c.generate(folderName, fileName)
+}
+
+// Generates, in the background, the thumbnails missing from a folder in
+// the given order. It does nothing if this is already being done.
+func (c *ThumbnailCache) warm(folderName string, fileNames []string) {
+	if len(fileNames) == 0 {
+		return
+	}
+	c.mu.Lock()
+	if c.warming[folderName] {
+		c.mu.Unlock()
+		return
+	}
+	c.warming[folderName] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.warming, folderName)
+			c.mu.Unlock()
+		}()
+		missing := 0
+		for _, fileName := range fileNames {
+			if _, found := c.get(folderName, fileName); found {
+				continue
+			}
+			if missing == 0 {
+				log.Println("Creating missing thumbnails of", folderName)
+			}
+			missing++
+			if _, err := c.getOrGenerate(folderName, fileName); err != nil {
+				log.Println("Cannot create thumbnail for", fileName, err)
+			}
+		}
+		if missing > 0 {
+			log.Printf("Created %d missing thumbnails of %s\n", missing, folderName)
+		}
+	}()
+}
+
+// Path of the preview of a frame for the `/thumbnails/` route, empty when
+// it was not generated yet.
+func (c *ThumbnailCache) previewPath(folderName string, fileName string) string {
+	frame, found := c.get(folderName, fileName)
+	if !found {
+		return ""
+	}
+	return folderName + "/" + frame.Preview
+}
+
+// Creates the thumbnail and preview of each new snap right after it was
+// captured, so that they do not need to be created when the folder is
+// opened.
+func CreateThumbnailPostProcessor(thumbnails *ThumbnailCache) capture.PostProcessor {
+	return func(r capture.Result) {
+		if r.Err != nil {
+			return
+		}
+		folderName := filepath.Base(filepath.Dir(r.SnapPath))
+		if _, err := thumbnails.generate(folderName, filepath.Base(r.SnapPath)); err != nil {
+			log.Println("Cannot create thumbnail for", r.SnapPath, err)
+		}
+	}
+}
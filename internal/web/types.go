@@ -1,5 +1,7 @@
 package web
 
+import "github.com/pyrho/timelapse-serial/internal/camera"
+
 type SnapInfo struct {
 	FolderName string
 	FileName   string
@@ -22,10 +24,28 @@ type TLInfo struct {
 	NumberOfSnaps     uint
 	HasTimelapseVideo bool
 	FolderPath        string
+	Captures          camera.CaptureStats
 }
 
 type Hi struct {
-	ThumbnailPath string
-	ix            int
-	ImgPath       string
+	ThumbnailURL string
+	// Position of the frame in the print, from 1
+	Number   int
+	ImgPath  string
+	Excluded bool
+	// Why the frame looks wrong, see the frame analysis
+	Flags []string
+}
+
+// A frame as listed by `/api/frames/{folderName}`.
+type FrameInfo struct {
+	// Position of the frame in the print, from 1
+	Number     int
+	FileName   string
+	PreviewURL string
+	ImageURL   string
+	// Left out of the videos
+	Excluded bool
+	// Why the frame looks wrong, see the frame analysis
+	Flags []string
 }